@@ -0,0 +1,146 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"sync"
+
+	"github.com/blocktop/movavg"
+)
+
+// Averaging selects how the moving averages of KernelMetrics are kept.
+type Averaging int
+
+const (
+	// AveragingSMA keeps exact simple moving averages. Each series
+	// holds as many samples as its largest window.
+	AveragingSMA Averaging = iota
+
+	// AveragingEMA keeps exponential moving averages weighted to
+	// approximate each window. Each series holds one value per window.
+	AveragingEMA
+)
+
+// Names of the metric series, used to disable them in KernelConfig.
+const (
+	SeriesCycle                = "cycle"
+	SeriesMaint                = "maint"
+	SeriesMaintPercent         = "maint_percent"
+	SeriesGenBlock             = "generate_block"
+	SeriesAddBlock             = "add_blocks"
+	SeriesConfBlock            = "confirm_blocks"
+	SeriesEval                 = "evaluate"
+	SeriesScheduledProc        = "scheduled_proc"
+	SeriesScheduledProcPercent = "scheduled_proc_percent"
+	SeriesProc                 = "proc"
+	SeriesProcPercent          = "proc_percent"
+	SeriesBlockQueue           = "block_queue"
+	SeriesReceiveQueue         = "receive_queue"
+	SeriesPipeline             = "pipeline"
+)
+
+var seriesNames = []string{
+	SeriesCycle, SeriesMaint, SeriesMaintPercent, SeriesGenBlock,
+	SeriesAddBlock, SeriesConfBlock, SeriesEval, SeriesScheduledProc,
+	SeriesScheduledProcPercent, SeriesProc, SeriesProcPercent,
+	SeriesBlockQueue, SeriesReceiveQueue, SeriesPipeline}
+
+func isSeriesName(name string) bool {
+	for _, s := range seriesNames {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+type movingAverage interface {
+	Add(float64)
+	Avg() []float64
+}
+
+func newMovingAverage(averaging Averaging, windows []int) movingAverage {
+	if averaging == AveragingEMA {
+		return newEMA(windows)
+	}
+	return &sma{movavg.NewMultiSMA(windows)}
+}
+
+type sma struct {
+	ma movavg.MultiMA
+}
+
+func (a *sma) Add(v float64) {
+	a.ma.Add(v)
+}
+
+func (a *sma) Avg() []float64 {
+	return a.ma.Avg()
+}
+
+// ema is an exponential moving average for each window, with smoothing
+// factor 2/(window+1).
+type ema struct {
+	mu      sync.Mutex
+	alphas  []float64
+	avgs    []float64
+	started bool
+}
+
+func newEMA(windows []int) *ema {
+	a := &ema{}
+	a.alphas = make([]float64, len(windows))
+	a.avgs = make([]float64, len(windows))
+	for i, w := range windows {
+		a.alphas[i] = 2 / (float64(w) + 1)
+	}
+	return a
+}
+
+func (a *ema) Add(v float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, alpha := range a.alphas {
+		if !a.started {
+			a.avgs[i] = v
+		} else {
+			a.avgs[i] += alpha * (v - a.avgs[i])
+		}
+	}
+	a.started = true
+}
+
+func (a *ema) Avg() []float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	avgs := make([]float64, len(a.avgs))
+	copy(avgs, a.avgs)
+	return avgs
+}
+
+// disabledAverage stands in for a series that is turned off.
+type disabledAverage struct {
+	windows int
+}
+
+func (a *disabledAverage) Add(v float64) {}
+
+func (a *disabledAverage) Avg() []float64 {
+	return make([]float64, a.windows)
+}
@@ -17,10 +17,11 @@
 package kernel
 
 import (
+	"errors"
+	"sort"
+	"sync/atomic"
 	"time"
 
-	"github.com/spf13/viper"
-
 	spec "github.com/blocktop/go-spec"
 	"github.com/golang/glog"
 )
@@ -35,6 +36,22 @@ type KernelBlock struct {
 	genesis    bool
 	genNum     uint64
 	rootID     int
+	paused     int32 // atomic
+
+	genesisProduced bool
+	localHitsLimit  int
+}
+
+// BranchInfo describes a competing branch as last evaluated by the
+// consensus during the maint timeslice.
+type BranchInfo struct {
+	RootID               int     `json:"rootID"`
+	HitRate              float64 `json:"hitRate"`
+	ConsecutiveLocalHits int     `json:"consecutiveLocalHits"`
+	Length               int     `json:"length"`
+	HeadBlockNumber      uint64  `json:"headBlockNumber,string"`
+	HeadHash             string  `json:"headHash"`
+	Current              bool    `json:"current"`
 }
 
 var blk *KernelBlock
@@ -45,11 +62,15 @@ func initBlock(c *KernelConfig) {
 	b.blockchain = c.Blockchain
 	b.consensus = c.Consensus
 	b.msgChan = NewMessageChannel(b.proto, b.recvHandler)
-	b.blockQs = newBlockQueues()
+	b.blockQs = newBlockQueues(c.BlockQueueCapacity, c.BlockBatchSize, b.blockBatchWorker)
+	tracer.protocol = b.msgChan.Protocol.String()
 
-	b.genesis = viper.GetBool("blockchain.genesis")
+	b.genesis = c.Genesis
+	b.localHitsLimit = c.ConsecutiveLocalHitsLimit
 
-	net.RegisterMessageChannel(b.msgChan)
+	if err := net.RegisterMessageChannel(b.msgChan); err != nil {
+		panic(err)
+	}
 
 	blk = b
 }
@@ -58,6 +79,53 @@ func (b *KernelBlock) BlockNumber() uint64 {
 	return b.genNum
 }
 
+func (b *KernelBlock) RootID() int {
+	return b.rootID
+}
+
+// PauseGeneration stops the kernel generating blocks from the next proc
+// timeslice. Blocks from peers continue to be added.
+func (b *KernelBlock) PauseGeneration() {
+	atomic.StoreInt32(&b.paused, 1)
+}
+
+func (b *KernelBlock) ResumeGeneration() {
+	atomic.StoreInt32(&b.paused, 0)
+}
+
+func (b *KernelBlock) GenerationPaused() bool {
+	return atomic.LoadInt32(&b.paused) == 1
+}
+
+func (b *KernelBlock) Branches() []BranchInfo {
+	comp := b.comp
+	if comp == nil {
+		return []BranchInfo{}
+	}
+	branches := comp.Branches()
+	res := make([]BranchInfo, 0, len(branches))
+	for rootID, branch := range branches {
+		info := BranchInfo{
+			RootID:               rootID,
+			HitRate:              branch.HitRate(),
+			ConsecutiveLocalHits: branch.ConsecutiveLocalHits(),
+			Current:              rootID == b.rootID}
+		blocks := branch.Blocks()
+		info.Length = len(blocks)
+		if len(blocks) > 0 {
+			info.HeadBlockNumber = blocks[0].BlockNumber()
+			info.HeadHash = blocks[0].Hash()
+		}
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].RootID < res[j].RootID })
+	return res
+}
+
+func (b *KernelBlock) Queues() []BlockQueueInfo {
+	return b.blockQs.summary()
+}
+
 func (b *KernelBlock) start() {
 	glog.V(3).Infof("%s: resuming new block processing", ktime.String())
 	b.blockQs.start()
@@ -89,9 +157,14 @@ func (b *KernelBlock) maint() {
 }
 
 func (b *KernelBlock) generate() {
+	if b.GenerationPaused() {
+		glog.V(3).Infof("%s: block generation is paused", ktime.String())
+		return
+	}
 	glog.V(3).Infof("%s: initiating block generation", ktime.String())
-	if b.genesis && b.genNum == 0 {
+	if b.genesis && !b.genesisProduced {
 		newBlock := b.blockchain.GenerateGenesis()
+		b.genesisProduced = true
 		b.genNum = 1
 		b.rootID = 1
 		b.outputNewLocalBlock(newBlock)
@@ -107,10 +180,12 @@ func (b *KernelBlock) generate() {
 	blocks := compBranch.Blocks()
 
 	startTime := time.Now().UnixNano()
+	faults.stall(FaultSlowGenerate)
 	newBlock := b.blockchain.GenerateBlock(blocks, compBranch.RootID())
 	endTime := time.Now().UnixNano()
 
 	metrics.setGenBlockTime(endTime - startTime)
+	reporter.update(func(c *CycleReport) { c.GenerateTime = time.Duration(endTime - startTime) })
 
 	b.outputNewLocalBlock(newBlock)
 }
@@ -137,7 +212,7 @@ func (b *KernelBlock) evaluateBranches() spec.CompetingBranch {
 	var bestRootID int
 
 	for rootID, branch := range branches {
-		if branch.HitRate() > maxHitRate && branch.ConsecutiveLocalHits() < 3 { //TODO: make consecutive hits threshold configurable?
+		if branch.HitRate() > maxHitRate && branch.ConsecutiveLocalHits() < b.localHitsLimit {
 			maxHitRate = branch.HitRate()
 			bestRootID = rootID
 		}
@@ -146,6 +221,7 @@ func (b *KernelBlock) evaluateBranches() spec.CompetingBranch {
 	bestBranch := branches[bestRootID]
 	if bestBranch != nil {
 		b.genNum = bestBranch.Blocks()[0].BlockNumber() + 1
+		publish(EventHeadSwitched, &HeadSwitchedEvent{FromRootID: b.rootID, ToRootID: bestRootID, BlockNumber: b.genNum})
 		b.rootID = bestRootID
 		b.consensus.SetConfirmingRoot(bestRootID)
 	}
@@ -162,11 +238,19 @@ func (b *KernelBlock) outputNewLocalBlock(newBlock spec.Block) bool {
 	glog.V(3).Infof("%s: generated local block %d:%s", ktime.String(), newBlock.BlockNumber(), newBlock.Hash()[:6])
 
 	// Locally-generated block bypasses the queues, add to consensus immediately.
-	res := b.blockchain.AddBlocks([]spec.Block{newBlock}, true)
+	res := b.addBlocks([]spec.Block{newBlock}, true)
 	if res.Error != nil {
+		metrics.addAddBlocksError()
+		reporter.addBlocksError()
 		glog.Errorln("Failed to add locally-generated block to consensus:", res.Error)
 	}
+	metrics.incGeneratedBlocks()
+	reporter.generated(newBlock)
+	publish(EventBlockGenerated, newBlockEvent(newBlock, true))
 	if res.AddedBlock != nil {
+		metrics.incAddedBlocks()
+		reporter.added(res.AddedBlock)
+		publish(EventBlockAdded, newBlockEvent(res.AddedBlock, true))
 		net.priorityBroadcast(netMsg)
 	}
 	return true
@@ -176,16 +260,27 @@ func (b *KernelBlock) recvHandler(netMsg *spec.NetworkMessage) {
 	panicIfUninitialized()
 	block, err := b.msgChan.unmarshal(netMsg)
 	if err != nil {
+		tracer.end(netMsg, err)
 		glog.Errorf("Failed to unmarshal block message from %s", netMsg.From[:6])
 		return
 	}
 
 	if block.Hash() != netMsg.Hash {
+		metrics.addHashMismatch(netMsg)
+		tracer.end(netMsg, errors.New("block data does not match message hash"))
 		glog.Errorln("block data does not match message hash from", netMsg.From[:6])
 		return
 	}
 
-	b.blockQs.put(block.(spec.Block), netMsg)
+	newBlock, ok := block.(spec.Block)
+	if !ok {
+		tracer.end(netMsg, errors.New("block message did not unmarshal to a block"))
+		glog.Errorf("block message from %s did not unmarshal to a block", netMsg.From[:6])
+		return
+	}
+
+	b.blockQs.put(newBlock, netMsg)
+	tracer.mark(netMsg, stageQueued)
 }
 
 func (k *Kernel) transactionMessageReceiver(netMsg *spec.NetworkMessage) {
@@ -199,40 +294,64 @@ func (b *KernelBlock) blockBatchWorker(items []*blockQueueItem, local bool) {
 	for i, item := range items {
 		blocks[i] = item.block
 		index[item.block.Hash()] = item.netMsg
+		tracer.mark(item.netMsg, stageBatched)
 	}
 
 	startTime := time.Now().UnixNano()
-	res := b.blockchain.AddBlocks(blocks, local)
+	res := b.addBlocks(blocks, local)
 	endTime := time.Now().UnixNano()
 	metrics.setAddBlockTime(endTime - startTime)
 
+	for _, item := range items {
+		tracer.mark(item.netMsg, stageAdded)
+	}
+
 	if res == nil {
+		b.endTraces(items, nil)
 		return // no blocks added
 	}
 
 	if res.Error != nil {
+		metrics.addAddBlocksError()
+		reporter.addBlocksError()
+		b.endTraces(items, res.Error)
 		glog.Errorln("failed to add blocks:", res.Error)
 		return
 	}
 
 	if res.AddedBlock != nil {
+		metrics.incAddedBlocks()
+		reporter.added(res.AddedBlock)
+		publish(EventBlockAdded, newBlockEvent(res.AddedBlock, local))
 		netMsg := index[res.AddedBlock.Hash()]
 		net.priorityBroadcast(netMsg)
+		tracer.mark(netMsg, stageBroadcast)
+	}
+	b.endTraces(items, nil)
+}
+
+func (b *KernelBlock) endTraces(items []*blockQueueItem, err error) {
+	for _, item := range items {
+		tracer.end(item.netMsg, err)
 	}
 }
 
 func (b *KernelBlock) makeNetMsg(block spec.Block) (*spec.NetworkMessage, error) {
-	data, links, err := block.Marshal()
+	return b.msgChan.marshal(block)
+}
+
+// NetworkMessage encodes the block as this node would send it to the
+// network.
+func (b *KernelBlock) NetworkMessage(block spec.Block) (*spec.NetworkMessage, error) {
+	netMsg, err := b.makeNetMsg(block)
 	if err != nil {
 		return nil, err
 	}
-	netMsg := &spec.NetworkMessage{
-		Data:     data,
-		Links:    links,
-		Hash:     block.Hash(),
-		Protocol: b.msgChan.Protocol,
-		From:     net.PeerID()}
+	return net.toWire(netMsg), nil
+}
 
-	//fmt.Printf("%v\n", netMsg)
-	return netMsg, nil
+// PeerMessage encodes the block as the given peer would send it to this
+// node. See MessageChannel.PeerMessage.
+func (b *KernelBlock) PeerMessage(block spec.Block, from string) (*spec.NetworkMessage, error) {
+	return b.msgChan.PeerMessage(block, from, nil)
 }
@@ -19,6 +19,7 @@ package kernel
 import (
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	push "github.com/blocktop/go-push-components"
 	spec "github.com/blocktop/go-spec"
@@ -34,6 +35,15 @@ type blockQueues struct {
 	queues           *sync.Map // [parentID]*blockQueue
 	blockNumberIndex *sync.Map // [blocknumber]mape[parentID]parentID
 	started          bool
+	capacity         int32 // atomic
+	batchSize        int32 // atomic
+	worker           func(items []*blockQueueItem, local bool)
+
+	// While the kernel is stepped, blocks are held in stepItems and
+	// added by processStep in the caller's goroutine.
+	stepMu    sync.Mutex
+	stepping  bool
+	stepItems []*blockQueueItem
 }
 
 type blockQueueItem struct {
@@ -41,13 +51,28 @@ type blockQueueItem struct {
 	netMsg *spec.NetworkMessage
 }
 
-func newBlockQueues() *blockQueues {
-	qs := &blockQueues{}
+func newBlockQueues(capacity int, batchSize int, worker func(items []*blockQueueItem, local bool)) *blockQueues {
+	qs := &blockQueues{worker: worker}
+	qs.setLimits(capacity, batchSize)
 	qs.queues = &sync.Map{}
 	qs.blockNumberIndex = &sync.Map{}
 	return qs
 }
 
+// setLimits sets the capacity and batch size of queues made from now on.
+func (qs *blockQueues) setLimits(capacity int, batchSize int) {
+	atomic.StoreInt32(&qs.capacity, int32(capacity))
+	atomic.StoreInt32(&qs.batchSize, int32(batchSize))
+}
+
+func (qs *blockQueues) capacityLimit() int {
+	return int(atomic.LoadInt32(&qs.capacity))
+}
+
+func (qs *blockQueues) batchSizeLimit() int {
+	return int(atomic.LoadInt32(&qs.batchSize))
+}
+
 func (qs *blockQueues) start() {
 	qs.started = true
 
@@ -119,7 +144,9 @@ func (qs *blockQueues) delete(parentID string) {
 }
 
 func (qs *blockQueues) count() int {
-	c := 0
+	qs.stepMu.Lock()
+	c := len(qs.stepItems)
+	qs.stepMu.Unlock()
 	qs.queues.Range(func(pid, q interface{}) bool {
 		c += q.(*blockQueue).blockQ.Count()
 		return true
@@ -127,11 +154,39 @@ func (qs *blockQueues) count() int {
 	return c
 }
 
+// BlockQueueInfo describes the queue of blocks waiting on one parent.
+type BlockQueueInfo struct {
+	ParentID    string `json:"parentID"`
+	BlockNumber uint64 `json:"blockNumber,string"`
+	Count       int    `json:"count"`
+}
+
+func (qs *blockQueues) summary() []BlockQueueInfo {
+	res := make([]BlockQueueInfo, 0)
+	qs.queues.Range(func(pid, q interface{}) bool {
+		bq := q.(*blockQueue)
+		res = append(res, BlockQueueInfo{ParentID: bq.parentID, BlockNumber: bq.blockNumber, Count: bq.blockQ.Count()})
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].BlockNumber < res[j].BlockNumber })
+	return res
+}
+
 func (qs *blockQueues) put(block spec.Block, netMsg *spec.NetworkMessage) {
+	qs.stepMu.Lock()
+	if qs.stepping {
+		if len(qs.stepItems) < qs.capacityLimit() {
+			qs.stepItems = append(qs.stepItems, &blockQueueItem{block, netMsg})
+		}
+		qs.stepMu.Unlock()
+		return
+	}
+	qs.stepMu.Unlock()
+
 	parentID := block.ParentHash()
 	q, ok := qs.queues.Load(parentID)
 	if !ok {
-		q = newBlockQueue(parentID, block.BlockNumber())
+		q = qs.newBlockQueue(parentID, block.BlockNumber())
 		qs.queues.Store(parentID, q)
 		pids, ok := qs.blockNumberIndex.Load(block.BlockNumber())
 		if !ok {
@@ -146,10 +201,10 @@ func (qs *blockQueues) put(block spec.Block, netMsg *spec.NetworkMessage) {
 	bq.blockQ.Put(bqi)
 }
 
-func newBlockQueue(parentID string, blockNumber uint64) *blockQueue {
+func (qs *blockQueues) newBlockQueue(parentID string, blockNumber uint64) *blockQueue {
 	q := &blockQueue{parentID: parentID, blockNumber: blockNumber}
-	q.blockQ = push.NewPushBatchQueue(1, 100000, 100, func(items []interface{}) {
-		blk.blockBatchWorker(castToBlockQueueItems(items), false)
+	q.blockQ = push.NewPushBatchQueue(1, qs.capacityLimit(), qs.batchSizeLimit(), func(items []interface{}) {
+		qs.worker(castToBlockQueueItems(items), false)
 	})
 	return q
 }
@@ -160,4 +215,63 @@ func castToBlockQueueItems(items []interface{}) []*blockQueueItem {
 		res[i] = item.(*blockQueueItem)
 	}
 	return res
-}
\ No newline at end of file
+}
+
+// beginStep holds blocks for processStep instead of queueing them.
+func (qs *blockQueues) beginStep() {
+	qs.stepMu.Lock()
+	defer qs.stepMu.Unlock()
+	qs.stepping = true
+}
+
+// endStep queues the blocks held for processStep.
+func (qs *blockQueues) endStep() {
+	qs.stepMu.Lock()
+	items := qs.stepItems
+	qs.stepItems = nil
+	qs.stepping = false
+	qs.stepMu.Unlock()
+
+	for _, item := range items {
+		qs.put(item.block, item.netMsg)
+	}
+}
+
+// processStep adds the held blocks, in order of block number and in
+// batches by parent as the queues would, and returns when every held
+// block has been passed to the worker.
+func (qs *blockQueues) processStep() {
+	for {
+		qs.stepMu.Lock()
+		items := qs.stepItems
+		qs.stepItems = nil
+		qs.stepMu.Unlock()
+		if len(items) == 0 {
+			return
+		}
+
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].block.BlockNumber() < items[j].block.BlockNumber()
+		})
+		batches := make(map[string][]*blockQueueItem)
+		parentIDs := make([]string, 0)
+		for _, item := range items {
+			parentID := item.block.ParentHash()
+			if _, ok := batches[parentID]; !ok {
+				parentIDs = append(parentIDs, parentID)
+			}
+			batches[parentID] = append(batches[parentID], item)
+		}
+		for _, parentID := range parentIDs {
+			batch := batches[parentID]
+			for len(batch) > 0 {
+				n := qs.batchSizeLimit()
+				if n <= 0 || n > len(batch) {
+					n = len(batch)
+				}
+				qs.worker(batch[:n], false)
+				batch = batch[n:]
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	spec "github.com/blocktop/go-spec"
+)
+
+// BroadcastFuture reports the outcome of a call to KernelNet.Broadcast.
+// Messages broadcast during the proc timeslice are held until the
+// timeslice ends, so the outcome may not be known when Broadcast returns.
+type BroadcastFuture struct {
+	done chan struct{}
+	err  error
+}
+
+type heldBroadcast struct {
+	netMsg *spec.NetworkMessage
+	future *BroadcastFuture
+}
+
+func newBroadcastFuture() *BroadcastFuture {
+	return &BroadcastFuture{done: make(chan struct{})}
+}
+
+// Done is closed once the message has been sent or dropped.
+func (f *BroadcastFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the message has been sent or dropped. It returns
+// nil if the message was handed to the network node, otherwise the
+// reason it was dropped.
+func (f *BroadcastFuture) Wait() error {
+	<-f.done
+	return f.err
+}
+
+// Sent reports whether the message has been handed to the network node.
+func (f *BroadcastFuture) Sent() bool {
+	select {
+	case <-f.done:
+		return f.err == nil
+	default:
+		return false
+	}
+}
+
+func (f *BroadcastFuture) resolve(err error) {
+	f.err = err
+	close(f.done)
+}
@@ -0,0 +1,224 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+// Command kerneld runs a kernel as a standalone daemon. The blockchain
+// and network node are chosen by name from the implementations
+// registered with kernel.RegisterBlockchain and kernel.RegisterNetwork,
+// either by packages linked into kerneld or by Go plugins listed in the
+// configuration, whose init functions register them.
+//
+// The configuration file, in any format viper reads (YAML, TOML, JSON),
+// may hold:
+//
+//	blockchain.name       registered blockchain (default "example")
+//	blockchain.producer   producer recorded in example blocks (default network.peerID)
+//	network.name          registered network (default "local")
+//	network.peerID        peer ID of the local network (default "kerneld")
+//	rpc.listen            address of the RPC and metrics server (default "127.0.0.1:9010")
+//	rpc.readerToken       bearer token granting read access
+//	rpc.adminToken        bearer token granting admin access
+//	state.path            file in which kernel state is kept across restarts,
+//	                      for blockchains that persist their blocks
+//	plugins               paths of Go plugins to load
+//
+// along with the kernel tunables read by KernelConfig.FromViper, such as
+// blockchain.genesis, kernel.blockFrequency (default 1) and
+// log.verbosity. Changes to the file are applied while kerneld runs,
+// where kernel.UpdateConfig allows.
+//
+// The blockchain and network factories are given the blockchain and
+// network sections of the configuration. The "local" network is an
+// example.Node with no peers: a node on it hears from no one, so it
+// must be the genesis node, and kerneld refuses to start otherwise.
+//
+// The server answers JSON-RPC 2.0 at /rpc, and serves /metrics,
+// /healthz, /readyz and /events.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"plugin"
+	"syscall"
+	"time"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/example"
+	spec "github.com/blocktop/go-spec"
+	"github.com/golang/glog"
+	"github.com/gorilla/rpc/v2"
+	"github.com/gorilla/rpc/v2/json2"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	kernel.RegisterBlockchain("example", func(settings map[string]interface{}) (*kernel.BlockchainPlugin, error) {
+		producer, _ := settings["producer"].(string)
+		chain, consensus := example.New(example.Config{Producer: producer})
+		return &kernel.BlockchainPlugin{
+			Blockchain:     chain,
+			Consensus:      consensus,
+			BlockPrototype: &example.Block{}}, nil
+	})
+	kernel.RegisterNetwork("local", func(settings map[string]interface{}) (spec.NetworkNode, error) {
+		peerID, _ := settings["peerid"].(string)
+		return example.NewNode(peerID), nil
+	})
+}
+
+func main() {
+	configFile := flag.String("config", "", "path of the configuration file")
+	flag.Parse()
+
+	v := viper.GetViper()
+	v.SetDefault("blockchain.name", "example")
+	v.SetDefault("blockchain.genesis", false)
+	v.SetDefault("network.name", "local")
+	v.SetDefault("network.peerID", "kerneld")
+	v.SetDefault("kernel.blockFrequency", 1.0)
+	v.SetDefault("rpc.listen", "127.0.0.1:9010")
+	v.SetDefault("log.verbosity", 0)
+	v.SetEnvPrefix("kerneld")
+	v.AutomaticEnv()
+	if *configFile != "" {
+		v.SetConfigFile(*configFile)
+		if err := v.ReadInConfig(); err != nil {
+			glog.Exitf("failed to read config: %v", err)
+		}
+	}
+	v.SetDefault("blockchain.producer", v.GetString("network.peerID"))
+
+	flag.Set("logtostderr", "true")
+
+	if err := run(v, *configFile != ""); err != nil {
+		glog.Exitln(err)
+	}
+	glog.Flush()
+}
+
+func run(v *viper.Viper, watch bool) error {
+	for _, path := range v.GetStringSlice("plugins") {
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("failed to load plugin %s: %v", path, err)
+		}
+		glog.Infof("loaded plugin %s", path)
+	}
+
+	name := v.GetString("blockchain.name")
+	newBlockchain, ok := kernel.LookupBlockchain(name)
+	if !ok {
+		return fmt.Errorf("blockchain %s is not registered, have %v", name, kernel.RegisteredBlockchains())
+	}
+	chain, err := newBlockchain(section(v, "blockchain"))
+	if err != nil {
+		return err
+	}
+	networkName := v.GetString("network.name")
+	if networkName == "local" && !v.GetBool("blockchain.genesis") {
+		return errors.New("the local network has no peers, so a node on it must be the genesis node")
+	}
+	newNetwork, ok := kernel.LookupNetwork(networkName)
+	if !ok {
+		return fmt.Errorf("network %s is not registered", networkName)
+	}
+	node, err := newNetwork(section(v, "network"))
+	if err != nil {
+		return err
+	}
+
+	c := &kernel.KernelConfig{
+		Blockchain:     chain.Blockchain,
+		Consensus:      chain.Consensus,
+		BlockPrototype: chain.BlockPrototype,
+		NetworkNode:    node}
+	if path := v.GetString("state.path"); path != "" {
+		c.StateStore = kernel.NewFileStateStore(path)
+	}
+	if err := c.FromViper(v); err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if v.GetString("rpc.readerToken") != "" || v.GetString("rpc.adminToken") != "" {
+		c.RPCAuthenticator = &kernel.BearerTokenAuthenticator{
+			ReaderToken: v.GetString("rpc.readerToken"),
+			AdminToken:  v.GetString("rpc.adminToken")}
+	}
+	kernel.Init(c)
+	if watch {
+		kernel.WatchConfig(v)
+	}
+
+	server := rpc.NewServer()
+	server.RegisterCodec(json2.NewCodec(), "application/json")
+	if err := server.RegisterService(new(kernel.RPC), "kernel"); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/rpc", server)
+	mux.Handle("/metrics", kernel.PrometheusHandler())
+	mux.Handle("/healthz", kernel.LivenessHandler())
+	mux.Handle("/readyz", kernel.ReadinessHandler())
+	mux.Handle("/events", kernel.EventStreamHandler())
+	httpServer := &http.Server{Addr: v.GetString("rpc.listen"), Handler: mux}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		glog.Infof("serving RPC on %s", httpServer.Addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	if err := node.Start(ctx); err != nil {
+		return err
+	}
+	kernel.Start(ctx)
+	glog.Infof("kernel started, blockchain %s, genesis %v", name, v.GetBool("blockchain.genesis"))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case sig := <-signals:
+		glog.Infof("received %s, stopping", sig)
+	case err := <-serveErr:
+		glog.Errorln("RPC server failed:", err)
+	}
+
+	kernel.Stop()
+	node.Stop()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
+
+// section returns a section of the configuration, with defaults, as the
+// nested maps a factory is given.
+func section(v *viper.Viper, name string) map[string]interface{} {
+	if m, ok := v.AllSettings()[name].(map[string]interface{}); ok {
+		return m
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+// Command kernelsim runs several kernels in one process, connected by
+// an in-memory network, and reports how well their chains converge.
+//
+// The kernels take turns: in each round every node receives the
+// messages broadcast to it since its last turn and runs one block cycle.
+// Node 0 produces the genesis block.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/example"
+	"github.com/golang/glog"
+)
+
+type simNode struct {
+	net       *example.Node
+	consensus *example.Consensus
+	instance  *kernel.Instance
+}
+
+func main() {
+	nodeCount := flag.Int("nodes", 5, "number of kernels to run")
+	cycles := flag.Int("cycles", 100, "number of block cycles to run")
+	frequency := flag.Float64("frequency", 50, "block frequency of every kernel, in blocks per second")
+	topology := flag.String("topology", "mesh", "network topology: mesh, ring, star or random")
+	degree := flag.Int("degree", 2, "extra links per node of the random topology")
+	forkDepth := flag.Uint64("fork-depth", 2, "blocks a branch may fall behind the highest before it is abandoned")
+	confirmDepth := flag.Uint64("confirm-depth", 3, "blocks below the highest head at which common blocks are confirmed")
+	seed := flag.Int64("seed", 1, "seed of the random topology")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	if *nodeCount < 1 || *cycles < 1 {
+		fmt.Fprintln(os.Stderr, "nodes and cycles must be positive")
+		os.Exit(2)
+	}
+
+	nets, err := newNetwork(*nodeCount, *topology, *degree, rand.New(rand.NewSource(*seed)))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	nodes := make([]*simNode, *nodeCount)
+	for i, n := range nets {
+		chain, consensus := example.New(example.Config{
+			Producer:     n.PeerID(),
+			ForkDepth:    *forkDepth,
+			ConfirmDepth: *confirmDepth})
+		kernel.Init(&kernel.KernelConfig{
+			Genesis:        i == 0,
+			Blockchain:     chain,
+			Consensus:      consensus,
+			BlockFrequency: *frequency,
+			BlockPrototype: &example.Block{},
+			NetworkNode:    n,
+			MetricsWindows: []int{10, 100}})
+		nodes[i] = &simNode{net: n, consensus: consensus, instance: kernel.Current()}
+	}
+
+	ctx := context.Background()
+	for c := 0; c < *cycles; c++ {
+		for _, node := range nodes {
+			if _, err := node.net.Step(ctx, node.instance); err != nil {
+				glog.Exitln(err)
+			}
+		}
+	}
+
+	report := newReport(nodes, *cycles)
+	for _, node := range nodes {
+		node.instance.Activate()
+		kernel.Stop()
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	fmt.Print(report.String())
+}
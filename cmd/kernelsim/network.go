@@ -0,0 +1,65 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/blocktop/go-kernel/example"
+)
+
+// newNetwork makes count nodes connected in the given topology: mesh,
+// ring, star (every node connected to node 0) or random (each node
+// connected to degree others chosen at random, plus a ring so that the
+// network is connected).
+func newNetwork(count int, topology string, degree int, rnd *rand.Rand) ([]*example.Node, error) {
+	nodes := make([]*example.Node, count)
+	for i := range nodes {
+		nodes[i] = example.NewNode(fmt.Sprintf("node%03d", i))
+	}
+	link := func(a, b int) {
+		example.Connect(nodes[a], nodes[b])
+	}
+
+	switch topology {
+	case "mesh":
+		for a := 0; a < count; a++ {
+			for b := a + 1; b < count; b++ {
+				link(a, b)
+			}
+		}
+	case "ring":
+		for a := 0; a < count; a++ {
+			link(a, (a+1)%count)
+		}
+	case "star":
+		for a := 1; a < count; a++ {
+			link(0, a)
+		}
+	case "random":
+		for a := 0; a < count; a++ {
+			link(a, (a+1)%count)
+			for i := 0; i < degree; i++ {
+				link(a, rnd.Intn(count))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown topology %q", topology)
+	}
+	return nodes, nil
+}
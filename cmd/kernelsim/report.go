@@ -0,0 +1,173 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/example"
+)
+
+type nodeReport struct {
+	PeerID          string  `json:"peerID"`
+	Height          uint64  `json:"height,string"`
+	ConfirmedHeight uint64  `json:"confirmedHeight,string"`
+	ConfirmedHash   string  `json:"confirmedHash"`
+	Forks           int     `json:"forks"`
+	Reorgs          int     `json:"reorgs"`
+	MaxReorgDepth   uint64  `json:"maxReorgDepth,string"`
+	MeanFinality    float64 `json:"meanFinality"`
+	GeneratedBlocks uint64  `json:"generatedBlocks,string"`
+	AddBlocksErrors uint64  `json:"addBlocksErrors,string"`
+	ProcOverruns    uint64  `json:"procOverruns,string"`
+}
+
+// simReport summarizes a simulation. Finality is measured in cycles
+// from the cycle a block was generated to the cycle a node confirmed
+// it.
+type simReport struct {
+	Nodes          int           `json:"nodes"`
+	Cycles         int           `json:"cycles"`
+	Converged      bool          `json:"converged"`
+	Forks          int           `json:"forks"`
+	Reorgs         int           `json:"reorgs"`
+	MeanReorgDepth float64       `json:"meanReorgDepth"`
+	MaxReorgDepth  uint64        `json:"maxReorgDepth,string"`
+	MeanFinality   float64       `json:"meanFinality"`
+	MaxFinality    uint64        `json:"maxFinality,string"`
+	Blocks         int           `json:"blocks"`
+	OrphanedBlocks int           `json:"orphanedBlocks"`
+	OrphanRate     float64       `json:"orphanRate"`
+	ProcOverruns   uint64        `json:"procOverruns,string"`
+	NodeReports    []*nodeReport `json:"nodeReports"`
+}
+
+func newReport(nodes []*simNode, cycles int) *simReport {
+	r := &simReport{Nodes: len(nodes), Cycles: cycles, NodeReports: make([]*nodeReport, 0, len(nodes))}
+
+	// Union of every block seen by any node, and the number of distinct
+	// children of each.
+	blocks := make(map[string]bool)
+	children := make(map[string]map[string]bool)
+	var reorgDepths, finalities uint64
+	var reorgCount, finalityCount int
+	var canonical []*example.Block
+
+	for _, node := range nodes {
+		node.instance.Activate()
+		stats := node.consensus.Stats()
+
+		nr := &nodeReport{
+			PeerID:          node.net.PeerID(),
+			Height:          stats.Height,
+			ConfirmedHeight: stats.ConfirmedHeight,
+			ConfirmedHash:   stats.ConfirmedHash,
+			Forks:           stats.Forks,
+			Reorgs:          len(stats.ReorgDepths)}
+		for _, d := range stats.ReorgDepths {
+			reorgDepths += d
+			if d > nr.MaxReorgDepth {
+				nr.MaxReorgDepth = d
+			}
+		}
+		reorgCount += len(stats.ReorgDepths)
+		if nr.MaxReorgDepth > r.MaxReorgDepth {
+			r.MaxReorgDepth = nr.MaxReorgDepth
+		}
+		var nodeFinality uint64
+		for _, f := range stats.Finality {
+			nodeFinality += f
+			if f > r.MaxFinality {
+				r.MaxFinality = f
+			}
+		}
+		if len(stats.Finality) > 0 {
+			nr.MeanFinality = float64(nodeFinality) / float64(len(stats.Finality))
+		}
+		finalities += nodeFinality
+		finalityCount += len(stats.Finality)
+
+		for _, b := range node.consensus.Blocks() {
+			hash := b.Hash()
+			blocks[hash] = true
+			if b.Parent != "" {
+				if children[b.Parent] == nil {
+					children[b.Parent] = make(map[string]bool)
+				}
+				children[b.Parent][hash] = true
+			}
+		}
+		if chain := node.consensus.Chain(); len(chain) > len(canonical) {
+			canonical = chain
+		}
+
+		m := kernel.Metrics()
+		nr.GeneratedBlocks = m.GeneratedBlocks()
+		nr.AddBlocksErrors = m.AddBlocksErrors()
+		nr.ProcOverruns = m.ProcOverruns()
+		r.ProcOverruns += nr.ProcOverruns
+		r.NodeReports = append(r.NodeReports, nr)
+	}
+
+	for _, c := range children {
+		if len(c) > 1 {
+			r.Forks += len(c) - 1
+		}
+	}
+	r.Reorgs = reorgCount
+	if reorgCount > 0 {
+		r.MeanReorgDepth = float64(reorgDepths) / float64(reorgCount)
+	}
+	if finalityCount > 0 {
+		r.MeanFinality = float64(finalities) / float64(finalityCount)
+	}
+
+	// Blocks not on the chain of the highest head are orphans.
+	r.Blocks = len(blocks)
+	r.OrphanedBlocks = len(blocks) - len(canonical)
+	if r.Blocks > 0 {
+		r.OrphanRate = float64(r.OrphanedBlocks) / float64(r.Blocks)
+	}
+
+	// The nodes have converged when they have confirmed the same block.
+	r.Converged = true
+	for _, nr := range r.NodeReports[1:] {
+		if nr.ConfirmedHash != r.NodeReports[0].ConfirmedHash {
+			r.Converged = false
+		}
+	}
+	return r
+}
+
+func (r *simReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Nodes: %d  Cycles: %d  Converged: %v\n", r.Nodes, r.Cycles, r.Converged)
+	fmt.Fprintf(&sb, "Blocks: %d  Orphaned: %d (%.1f%%)  Forks: %d\n", r.Blocks, r.OrphanedBlocks, r.OrphanRate*100, r.Forks)
+	fmt.Fprintf(&sb, "Reorgs: %d  Mean depth: %.2f  Max depth: %d\n", r.Reorgs, r.MeanReorgDepth, r.MaxReorgDepth)
+	fmt.Fprintf(&sb, "Finality (cycles): mean %.2f  max %d\n", r.MeanFinality, r.MaxFinality)
+	fmt.Fprintf(&sb, "Proc overruns: %d\n\n", r.ProcOverruns)
+	fmt.Fprintf(&sb, "%-8s %8s %9s %6s %6s %9s %9s %9s %8s %8s\n",
+		"Node", "Height", "Confirmed", "Forks", "Reorgs", "MaxReorg", "Finality", "Generated", "AddErrs", "Overruns")
+	for _, nr := range r.NodeReports {
+		fmt.Fprintf(&sb, "%-8s %8d %9d %6d %6d %9d %9.2f %9d %8d %8d\n",
+			nr.PeerID, nr.Height, nr.ConfirmedHeight, nr.Forks, nr.Reorgs, nr.MaxReorgDepth,
+			nr.MeanFinality, nr.GeneratedBlocks, nr.AddBlocksErrors, nr.ProcOverruns)
+	}
+	return sb.String()
+}
@@ -0,0 +1,179 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifies the compression applied to message data. The codec
+// is recorded in the message envelope, so receivers decode any codec
+// regardless of their own channel settings.
+type Codec byte
+
+const (
+	CodecNone Codec = iota
+	CodecGzip
+	CodecZstd
+	CodecSnappy
+)
+
+// envelope flag bits holding the Codec
+const flagCodecMask byte = 0x07
+
+var zstdEncoder *zstd.Encoder
+var zstdEncoderOnce sync.Once
+
+// zstdDecoders pools zstd decoders by decode limit, since the limit is
+// fixed when a decoder is created.
+var zstdDecoders = &sync.Map{} // [limit]*sync.Pool
+
+func (c Codec) String() string {
+	switch c {
+	case CodecNone:
+		return "none"
+	case CodecGzip:
+		return "gzip"
+	case CodecZstd:
+		return "zstd"
+	case CodecSnappy:
+		return "snappy"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(c))
+	}
+}
+
+func compress(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+
+	case CodecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case CodecZstd:
+		var err error
+		zstdEncoderOnce.Do(func() {
+			zstdEncoder, err = zstd.NewWriter(nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if zstdEncoder == nil {
+			return nil, fmt.Errorf("zstd encoder is unavailable")
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+
+	case CodecSnappy:
+		return snappy.Encode(nil, data), nil
+
+	default:
+		return nil, fmt.Errorf("unknown compression codec %d", byte(codec))
+	}
+}
+
+// decompress decodes data, refusing to produce more than limit bytes.
+// The limit is checked before the output is allocated where the codec
+// records the decoded length, and while reading otherwise.
+func decompress(codec Codec, data []byte, limit int) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		if len(data) > limit {
+			return nil, fmt.Errorf("message size %d exceeds limit of %d bytes", len(data), limit)
+		}
+		return data, nil
+
+	case CodecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readLimited(r, limit)
+
+	case CodecZstd:
+		r, err := getZstdDecoder(limit)
+		if err != nil {
+			return nil, err
+		}
+		defer putZstdDecoder(limit, r)
+		if err := r.Reset(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+		return readLimited(r, limit)
+
+	case CodecSnappy:
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > limit {
+			return nil, fmt.Errorf("decoded message size %d exceeds limit of %d bytes", n, limit)
+		}
+		return snappy.Decode(nil, data)
+
+	default:
+		return nil, fmt.Errorf("unknown compression codec %d", byte(codec))
+	}
+}
+
+func getZstdDecoder(limit int) (*zstd.Decoder, error) {
+	p, _ := zstdDecoders.LoadOrStore(limit, &sync.Pool{})
+	if d, ok := p.(*sync.Pool).Get().(*zstd.Decoder); ok {
+		return d, nil
+	}
+	return zstd.NewReader(nil,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxMemory(uint64(limit)))
+}
+
+func putZstdDecoder(limit int, d *zstd.Decoder) {
+	// release the reference to the message data before pooling
+	if err := d.Reset(nil); err != nil {
+		d.Close()
+		return
+	}
+	p, _ := zstdDecoders.Load(limit)
+	p.(*sync.Pool).Put(d)
+}
+
+func readLimited(r io.Reader, limit int) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("decoded message size exceeds limit of %d bytes", limit)
+	}
+	return data, nil
+}
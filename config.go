@@ -16,7 +16,28 @@
 
 package kernel
 
-import spec "github.com/blocktop/go-spec"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	spec "github.com/blocktop/go-spec"
+	"github.com/spf13/viper"
+)
+
+// Defaults of the KernelConfig tunables.
+const (
+	DefaultQueueCapacity             = 100000
+	DefaultBlockBatchSize            = 100
+	DefaultBroadcastBatchSize        = 1000
+	DefaultConsecutiveLocalHitsLimit = 3
+	DefaultMaxVersionPeers           = 1024
+	DefaultVersionPeerCycles         = 100
+	DefaultMaxTrafficPeers           = 1000
+	DefaultTrafficPeerCycles         = 1000
+	DefaultTrafficTopN               = 10
+)
 
 type KernelConfig struct {
 	Blockchain spec.Blockchain
@@ -26,10 +47,275 @@ type KernelConfig struct {
 	BlockFrequency float64
 	BlockPrototype spec.Marshalled
 	NetworkNode    spec.NetworkNode
+
+	// Genesis makes this node generate the genesis block in its first
+	// cycle. Default false.
+	Genesis bool
+
+	// HoldQueueCapacity is the number of broadcasts held during the proc
+	// timeslice, beyond which broadcasts are dropped. ReceiveQueueCapacity
+	// is the number of inbound messages queued per protocol, and
+	// BlockQueueCapacity the number of blocks queued per parent block.
+	// Default DefaultQueueCapacity each.
+	HoldQueueCapacity    int
+	ReceiveQueueCapacity int
+	BlockQueueCapacity   int
+
+	// BlockBatchSize is the number of queued blocks passed to each
+	// AddBlocks call. Default DefaultBlockBatchSize.
+	BlockBatchSize int
+
+	// BroadcastBatchSize is the number of held broadcasts passed to the
+	// network node at once. Default DefaultBroadcastBatchSize.
+	BroadcastBatchSize int
+
+	// ConsecutiveLocalHitsLimit excludes branches with at least this many
+	// consecutive locally generated blocks when the kernel chooses a new
+	// branch to generate on. Default DefaultConsecutiveLocalHitsLimit.
+	ConsecutiveLocalHitsLimit int
+
+	// LogVerbosity, when not zero, sets the glog verbosity (-v). Default
+	// 0 leaves, or on a config update restores, the verbosity as set on
+	// the command line.
+	LogVerbosity int
+
+	// MaxVersionPeers is the number of peers whose format versions are
+	// tracked. Announcements from further peers are ignored until tracked
+	// peers are forgotten, which happens to peers not heard from within
+	// VersionPeerCycles cycles. Default DefaultMaxVersionPeers and
+	// DefaultVersionPeerCycles.
+	MaxVersionPeers   int
+	VersionPeerCycles int
+
+	// MaxTrafficPeers is the number of peers whose traffic is counted
+	// separately. Traffic of further peers, and of peers evicted for not
+	// being heard from within TrafficPeerCycles cycles, is counted under
+	// TrafficOther. Default DefaultMaxTrafficPeers and
+	// DefaultTrafficPeerCycles.
+	MaxTrafficPeers   int
+	TrafficPeerCycles int
+
+	// TrafficTopN is the number of peers listed in each traffic summary
+	// of the metrics text and JSON output. Default DefaultTrafficTopN.
+	TrafficTopN int
+
+	// MetricsWindows are the sample counts of the moving averages kept
+	// for each metric. Default SMAWindows.
+	MetricsWindows []int
+
+	// MetricsAveraging selects exact simple moving averages (the
+	// default) or exponential moving averages, which keep no sample
+	// history and so use far less memory with large windows.
+	MetricsAveraging Averaging
+
+	// DisabledMetrics names metric series, such as SeriesGenBlock, that
+	// are not recorded. SeriesMaint cannot be disabled.
+	DisabledMetrics []string
+
+	// MetricsRecorder, when set, receives a row of metrics at the end of
+	// every cycle.
+	MetricsRecorder *MetricsRecorder
+
+	// MessageRecorder, when set, logs every inbound message with the
+	// cycle and cycle offset it arrived at, for replay with ReplayNode.
+	MessageRecorder *MessageRecorder
+
+	// Faults, when set, injects the faults added to it into the block
+	// pipeline. It is meant for testing only.
+	Faults *FaultInjector
+
+	// SpanExporter, when set, receives a trace of every block received
+	// from a peer, with a span for each stage of the receive pipeline.
+	SpanExporter SpanExporter
+
+	// StateStore, when set, persists the block number to generate, the
+	// current root, the cycle number and whether genesis was produced,
+	// so that a restarted kernel resumes where it stopped. Set it only
+	// with a blockchain that persists its blocks.
+	StateStore StateStore
+
+	// Health sets the thresholds of the health and readiness checks.
+	Health HealthConfig
+
+	// RPCAuthenticator determines the role of each RPC caller. When nil,
+	// only callers on a loopback address are allowed, with RoleAdmin.
+	RPCAuthenticator RPCAuthenticator
+
+	// RPCAuditLog, when set, receives a JSON line for every denied RPC
+	// call and every call to an admin method.
+	RPCAuditLog io.Writer
 }
 
-func (c *KernelConfig) valid() bool {
-	return c.Blockchain != nil && c.Consensus != nil &&
-		c.NetworkNode != nil && c.BlockFrequency > 0 &&
-		c.BlockPrototype != nil
+// Validate reports the first problem with the configuration, if any.
+func (c *KernelConfig) Validate() error {
+	if c.Blockchain == nil || c.Consensus == nil || c.NetworkNode == nil || c.BlockPrototype == nil {
+		return errors.New("Blockchain, Consensus, NetworkNode and BlockPrototype are required")
+	}
+	if c.BlockFrequency <= 0 {
+		return errors.New("BlockFrequency must be positive")
+	}
+	for name, v := range map[string]int{
+		"HoldQueueCapacity":         c.HoldQueueCapacity,
+		"ReceiveQueueCapacity":      c.ReceiveQueueCapacity,
+		"BlockQueueCapacity":        c.BlockQueueCapacity,
+		"BlockBatchSize":            c.BlockBatchSize,
+		"BroadcastBatchSize":        c.BroadcastBatchSize,
+		"ConsecutiveLocalHitsLimit": c.ConsecutiveLocalHitsLimit,
+		"LogVerbosity":              c.LogVerbosity,
+		"MaxVersionPeers":           c.MaxVersionPeers,
+		"VersionPeerCycles":         c.VersionPeerCycles,
+		"MaxTrafficPeers":           c.MaxTrafficPeers,
+		"TrafficPeerCycles":         c.TrafficPeerCycles,
+		"TrafficTopN":               c.TrafficTopN} {
+		if v < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	for _, w := range c.MetricsWindows {
+		if w <= 0 {
+			return errors.New("MetricsWindows must be positive")
+		}
+	}
+	for _, series := range c.DisabledMetrics {
+		if !isSeriesName(series) {
+			return fmt.Errorf("unknown metrics series %q in DisabledMetrics", series)
+		}
+		if series == SeriesMaint {
+			// proc time is scheduled from the maint time average
+			return fmt.Errorf("metrics series %s cannot be disabled", series)
+		}
+	}
+	if c.MetricsAveraging != AveragingSMA && c.MetricsAveraging != AveragingEMA {
+		return fmt.Errorf("unknown MetricsAveraging %d", c.MetricsAveraging)
+	}
+	if c.Health.MinPeers != nil && *c.Health.MinPeers < 0 {
+		return errors.New("Health.MinPeers must not be negative")
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the configuration with the default of
+// every unset tunable filled in.
+func (c *KernelConfig) withDefaults() *KernelConfig {
+	d := *c
+	setDefault := func(v *int, def int) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	setDefault(&d.HoldQueueCapacity, DefaultQueueCapacity)
+	setDefault(&d.ReceiveQueueCapacity, DefaultQueueCapacity)
+	setDefault(&d.BlockQueueCapacity, DefaultQueueCapacity)
+	setDefault(&d.BlockBatchSize, DefaultBlockBatchSize)
+	setDefault(&d.BroadcastBatchSize, DefaultBroadcastBatchSize)
+	setDefault(&d.ConsecutiveLocalHitsLimit, DefaultConsecutiveLocalHitsLimit)
+	setDefault(&d.MaxVersionPeers, DefaultMaxVersionPeers)
+	setDefault(&d.VersionPeerCycles, DefaultVersionPeerCycles)
+	setDefault(&d.MaxTrafficPeers, DefaultMaxTrafficPeers)
+	setDefault(&d.TrafficPeerCycles, DefaultTrafficPeerCycles)
+	setDefault(&d.TrafficTopN, DefaultTrafficTopN)
+	if len(d.MetricsWindows) == 0 {
+		d.MetricsWindows = SMAWindows
+	}
+	return &d
+}
+
+// FromViper sets the tunables present in v, leaving the others as they
+// are. The keys are:
+//
+//	blockchain.genesis
+//	kernel.blockFrequency
+//	kernel.holdQueueCapacity
+//	kernel.receiveQueueCapacity
+//	kernel.blockQueueCapacity
+//	kernel.blockBatchSize
+//	kernel.broadcastBatchSize
+//	kernel.consecutiveLocalHitsLimit
+//	kernel.maxVersionPeers
+//	kernel.versionPeerCycles
+//	log.verbosity
+//	metrics.windows
+//	metrics.averaging       "sma" or "ema"
+//	metrics.disabled
+//	metrics.maxTrafficPeers
+//	metrics.trafficPeerCycles
+//	metrics.trafficTopN
+func (c *KernelConfig) FromViper(v *viper.Viper) error {
+	if v.IsSet("blockchain.genesis") {
+		c.Genesis = v.GetBool("blockchain.genesis")
+	}
+	if v.IsSet("kernel.blockFrequency") {
+		c.BlockFrequency = v.GetFloat64("kernel.blockFrequency")
+	}
+	for key, field := range map[string]*int{
+		"kernel.holdQueueCapacity":         &c.HoldQueueCapacity,
+		"kernel.receiveQueueCapacity":      &c.ReceiveQueueCapacity,
+		"kernel.blockQueueCapacity":        &c.BlockQueueCapacity,
+		"kernel.blockBatchSize":            &c.BlockBatchSize,
+		"kernel.broadcastBatchSize":        &c.BroadcastBatchSize,
+		"kernel.consecutiveLocalHitsLimit": &c.ConsecutiveLocalHitsLimit,
+		"kernel.maxVersionPeers":           &c.MaxVersionPeers,
+		"kernel.versionPeerCycles":         &c.VersionPeerCycles,
+		"log.verbosity":                    &c.LogVerbosity,
+		"metrics.maxTrafficPeers":          &c.MaxTrafficPeers,
+		"metrics.trafficPeerCycles":        &c.TrafficPeerCycles,
+		"metrics.trafficTopN":              &c.TrafficTopN} {
+		if v.IsSet(key) {
+			*field = v.GetInt(key)
+		}
+	}
+	if v.IsSet("metrics.windows") {
+		c.MetricsWindows = v.GetIntSlice("metrics.windows")
+	}
+	if v.IsSet("metrics.averaging") {
+		switch strings.ToLower(v.GetString("metrics.averaging")) {
+		case "sma":
+			c.MetricsAveraging = AveragingSMA
+		case "ema":
+			c.MetricsAveraging = AveragingEMA
+		default:
+			return fmt.Errorf("metrics.averaging must be sma or ema, not %s", v.GetString("metrics.averaging"))
+		}
+	}
+	if v.IsSet("metrics.disabled") {
+		c.DisabledMetrics = v.GetStringSlice("metrics.disabled")
+	}
+	return nil
+}
+
+func (c *KernelConfig) String() string {
+	averaging := "sma"
+	if c.MetricsAveraging == AveragingEMA {
+		averaging = "ema"
+	}
+	name := ""
+	if c.Blockchain != nil {
+		name = c.Blockchain.Name()
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "blockchain: %s\n", name)
+	fmt.Fprintf(&sb, "genesis: %v\n", c.Genesis)
+	fmt.Fprintf(&sb, "block frequency: %v\n", c.BlockFrequency)
+	fmt.Fprintf(&sb, "hold queue capacity: %d\n", c.HoldQueueCapacity)
+	fmt.Fprintf(&sb, "receive queue capacity: %d\n", c.ReceiveQueueCapacity)
+	fmt.Fprintf(&sb, "block queue capacity: %d\n", c.BlockQueueCapacity)
+	fmt.Fprintf(&sb, "block batch size: %d\n", c.BlockBatchSize)
+	fmt.Fprintf(&sb, "broadcast batch size: %d\n", c.BroadcastBatchSize)
+	fmt.Fprintf(&sb, "consecutive local hits limit: %d\n", c.ConsecutiveLocalHitsLimit)
+	fmt.Fprintf(&sb, "log verbosity: %d\n", c.LogVerbosity)
+	fmt.Fprintf(&sb, "max version peers: %d\n", c.MaxVersionPeers)
+	fmt.Fprintf(&sb, "version peer cycles: %d\n", c.VersionPeerCycles)
+	fmt.Fprintf(&sb, "metrics windows: %v\n", c.MetricsWindows)
+	fmt.Fprintf(&sb, "metrics averaging: %s\n", averaging)
+	fmt.Fprintf(&sb, "disabled metrics: %v\n", c.DisabledMetrics)
+	fmt.Fprintf(&sb, "max traffic peers: %d\n", c.MaxTrafficPeers)
+	fmt.Fprintf(&sb, "traffic peer cycles: %d\n", c.TrafficPeerCycles)
+	fmt.Fprintf(&sb, "traffic top n: %d\n", c.TrafficTopN)
+	fmt.Fprintf(&sb, "metrics recorder: %v\n", c.MetricsRecorder != nil)
+	fmt.Fprintf(&sb, "message recorder: %v\n", c.MessageRecorder != nil)
+	fmt.Fprintf(&sb, "span exporter: %v\n", c.SpanExporter != nil)
+	fmt.Fprintf(&sb, "fault injection: %v\n", c.Faults != nil)
+	fmt.Fprintf(&sb, "state store: %v\n", c.StateStore != nil)
+	return sb.String()
 }
@@ -0,0 +1,158 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// CycleReport summarizes one proc/maint block cycle.
+type CycleReport struct {
+	CycleNumber        uint64        `json:"cycleNumber,string"`
+	GeneratedBlock     spec.Block    `json:"-"`
+	AddedBlocks        []spec.Block  `json:"-"`
+	AddBlocksErrors    int           `json:"addBlocksErrors"`
+	BroadcastsReleased int           `json:"broadcastsReleased"`
+	ComputedProcTime   time.Duration `json:"computedProcTime"`
+	ProcTime           time.Duration `json:"procTime"`
+	GenerateTime       time.Duration `json:"generateTime"`
+	MaintTime          time.Duration `json:"maintTime"`
+}
+
+type cycleReporter struct {
+	sync.Mutex
+	cur  *CycleReport
+	last *CycleReport
+}
+
+var reporter *cycleReporter
+
+func initCycleReporter() {
+	reporter = &cycleReporter{}
+}
+
+// Step runs exactly one block cycle synchronously and reports what
+// happened during it. Messages delivered to the network node before the
+// call are handled, and the blocks they carry added, in the caller's
+// goroutine before the kernel generates; nothing is left running when
+// Step returns. Step does not wait out the proc timeslice. If the
+// context is done partway through, the rest of the proc timeslice is
+// skipped, the cycle's maint still runs, and the report is returned
+// with the context's error.
+//
+// Step may not be called while the kernel is started. Start takes over
+// a stepped kernel; Stop ends stepping.
+func Step(ctx context.Context) (*CycleReport, error) {
+	panicIfUninitialized()
+	kernel.runMu.Lock()
+	defer kernel.runMu.Unlock()
+	if Started() {
+		return nil, errors.New("cannot step a started kernel")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !kernel.isStepping() {
+		atomic.StoreInt32(&kernel.stepping, 1)
+		net.beginStep()
+		blk.blockQs.beginStep()
+		ktime.up()
+	}
+
+	ktime.startCycle()
+	reporter.begin(ktime.CycleNumber())
+	err := kernel.stepProc(ctx)
+	return kernel.endCycle(), err
+}
+
+// RunCycles calls Step n times, stopping early if the context is done.
+func RunCycles(ctx context.Context, n int) ([]*CycleReport, error) {
+	reports := make([]*CycleReport, 0, n)
+	for i := 0; i < n; i++ {
+		r, err := Step(ctx)
+		if r != nil {
+			reports = append(reports, r)
+		}
+		if err != nil {
+			return reports, err
+		}
+	}
+	return reports, nil
+}
+
+// LastCycleReport returns the report of the most recently completed
+// cycle, or nil if no cycle has completed.
+func LastCycleReport() *CycleReport {
+	panicIfUninitialized()
+	reporter.Lock()
+	defer reporter.Unlock()
+	return reporter.last
+}
+
+func (k *Kernel) beginCycle() {
+	ktime.startCycle()
+	reporter.begin(ktime.CycleNumber())
+	k.proc()
+}
+
+func (k *Kernel) endCycle() *CycleReport {
+	k.maint()
+	return reporter.end()
+}
+
+func (r *cycleReporter) begin(cycle uint64) {
+	r.Lock()
+	defer r.Unlock()
+	r.cur = &CycleReport{CycleNumber: cycle, AddedBlocks: make([]spec.Block, 0)}
+}
+
+func (r *cycleReporter) end() *CycleReport {
+	r.Lock()
+	defer r.Unlock()
+	if r.cur != nil {
+		r.last = r.cur
+		r.cur = nil
+	}
+	return r.last
+}
+
+func (r *cycleReporter) update(f func(c *CycleReport)) {
+	r.Lock()
+	defer r.Unlock()
+	if r.cur != nil {
+		f(r.cur)
+	}
+}
+
+func (r *cycleReporter) generated(block spec.Block) {
+	r.update(func(c *CycleReport) { c.GeneratedBlock = block })
+}
+
+func (r *cycleReporter) added(block spec.Block) {
+	r.update(func(c *CycleReport) { c.AddedBlocks = append(c.AddedBlocks, block) })
+}
+
+func (r *cycleReporter) addBlocksError() {
+	r.update(func(c *CycleReport) { c.AddBlocksErrors++ })
+}
@@ -0,0 +1,131 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/binary"
+	"errors"
+
+	spec "github.com/blocktop/go-spec"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Within the kernel, message data of a MessageChannel is held in an
+// envelope:
+//
+//	byte 0     envelopeMagic
+//	bytes 1-2  format version, big endian
+//	byte 3     flags
+//	bytes 4-67 ed25519 signature, present when flagSigned is set
+//	remainder  payload
+//
+// On the network, envelopes travel under a protocol of their own, the
+// channel's envelope protocol, so that they are never confused with the
+// bare data that nodes predating versioning send under the channel's
+// protocol. Bare data is sent when the envelope holds nothing but a
+// baseVersion payload and some peer may not understand envelopes.
+const (
+	envelopeMagic     byte = 0xb7
+	envelopeHeaderLen      = 4
+)
+
+// envelopeProtocolSuffix is appended to the kernel name to form the
+// envelope protocols of its channels.
+const envelopeProtocolSuffix = "-envelope"
+
+type envelope struct {
+	version   uint16
+	flags     byte
+	signature []byte
+	payload   []byte
+}
+
+func (e *envelope) header() []byte {
+	h := make([]byte, envelopeHeaderLen)
+	h[0] = envelopeMagic
+	binary.BigEndian.PutUint16(h[1:3], e.version)
+	h[3] = e.flags
+	return h
+}
+
+func (e *envelope) encode() []byte {
+	data := make([]byte, 0, envelopeHeaderLen+len(e.signature)+len(e.payload))
+	data = append(data, e.header()...)
+	if e.flags&flagSigned != 0 {
+		data = append(data, e.signature...)
+	}
+	return append(data, e.payload...)
+}
+
+func decodeEnvelope(data []byte) (*envelope, error) {
+	if len(data) < envelopeHeaderLen {
+		return nil, errors.New("message envelope is truncated")
+	}
+	if data[0] != envelopeMagic {
+		return nil, errors.New("message data is not in an envelope")
+	}
+	e := &envelope{
+		version: binary.BigEndian.Uint16(data[1:3]),
+		flags:   data[3],
+		payload: data[envelopeHeaderLen:]}
+	if e.flags&flagSigned != 0 {
+		if len(e.payload) < ed25519.SignatureSize {
+			return nil, errors.New("message signature is truncated")
+		}
+		e.signature = e.payload[:ed25519.SignatureSize]
+		e.payload = e.payload[ed25519.SignatureSize:]
+	}
+	return e, nil
+}
+
+// toWire returns the message as it is sent to the network.
+func (n *KernelNet) toWire(netMsg *spec.NetworkMessage) *spec.NetworkMessage {
+	c, ok := n.channels.Load(netMsg.Protocol.String())
+	if !ok {
+		return netMsg
+	}
+	channel := c.(*MessageChannel)
+	wire := *netMsg
+	if !n.useEnvelope(channel) {
+		env, err := decodeEnvelope(netMsg.Data)
+		if err == nil && env.version == baseVersion && env.flags == 0 {
+			wire.Data = env.payload
+			return &wire
+		}
+	}
+	wire.Protocol = channel.envelopeProtocol
+	return &wire
+}
+
+// fromWire returns a message received from the network as it is held
+// within the kernel. Bare data is placed in a baseVersion envelope.
+func (n *KernelNet) fromWire(netMsg *spec.NetworkMessage) *spec.NetworkMessage {
+	if netMsg.Protocol == nil {
+		return netMsg
+	}
+	msg := *netMsg
+	if c, ok := n.envelopeChannels.Load(netMsg.Protocol.String()); ok {
+		msg.Protocol = c.(*MessageChannel).Protocol
+		return &msg
+	}
+	if _, ok := n.channels.Load(netMsg.Protocol.String()); ok {
+		env := &envelope{version: baseVersion, payload: netMsg.Data}
+		msg.Data = env.encode()
+		return &msg
+	}
+	return netMsg
+}
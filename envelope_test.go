@@ -0,0 +1,129 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build go1.18
+// +build go1.18
+
+package kernel_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/kerneltest"
+	spec "github.com/blocktop/go-spec"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		codec  kernel.Codec
+		signed bool
+	}{
+		{"bare", kernel.CodecNone, false},
+		{"gzip signed", kernel.CodecGzip, true},
+		{"zstd signed", kernel.CodecZstd, true},
+		{"snappy signed", kernel.CodecSnappy, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := kerneltest.NewHarness("test", "node")
+			h.Init()
+			defer kernel.Stop()
+
+			received := make([]*note, 0)
+			c, err := kernel.NewTypedMessageChannel(func(item *note, netMsg *spec.NetworkMessage) {
+				received = append(received, item)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Compression = test.codec
+			var key ed25519.PrivateKey
+			if test.signed {
+				pub, priv := newKey(t)
+				_, nodeKey := newKey(t)
+				keys := kernel.NewMemoryKeyStore(nodeKey)
+				keys.AddPeerKey("peer1", pub)
+				c.KeyStore = keys
+				key = priv
+			}
+			if err := kernel.Network().RegisterMessageChannel(c.MessageChannel); err != nil {
+				t.Fatal(err)
+			}
+
+			text := string(bytes.Repeat([]byte("envelope "), 100))
+			netMsg, err := c.PeerMessage(&note{Text: text}, "peer1", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.signed && netMsg.Protocol.String() != c.Protocol.String() {
+				t.Errorf("plain message sent on %s, want the base protocol", netMsg.Protocol.String())
+			}
+			h.Network.Deliver(netMsg)
+
+			if _, err := h.Step(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if len(received) != 1 || received[0].Text != text {
+				t.Fatalf("received %d messages, want the one delivered", len(received))
+			}
+			stats := kernel.Metrics().CompressionStatsMap()[c.Protocol.String()]
+			if stats.InboundRawBytes == 0 {
+				t.Error("inbound bytes were not counted")
+			}
+		})
+	}
+}
+
+func TestEnvelopeRejectsTruncatedData(t *testing.T) {
+	h := kerneltest.NewHarness("test", "node")
+	h.Init()
+	defer kernel.Stop()
+
+	received := 0
+	c, err := kernel.NewTypedMessageChannel(func(item *note, netMsg *spec.NetworkMessage) {
+		received++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Compression = kernel.CodecGzip
+	pub, priv := newKey(t)
+	_, nodeKey := newKey(t)
+	keys := kernel.NewMemoryKeyStore(nodeKey)
+	keys.AddPeerKey("peer1", pub)
+	c.KeyStore = keys
+	if err := kernel.Network().RegisterMessageChannel(c.MessageChannel); err != nil {
+		t.Fatal(err)
+	}
+
+	netMsg, err := c.PeerMessage(&note{Text: "truncated"}, "peer1", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	netMsg.Data = netMsg.Data[:len(netMsg.Data)/2]
+	h.Network.Deliver(netMsg)
+
+	if _, err := h.Step(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if received != 0 {
+		t.Error("a truncated message was received")
+	}
+}
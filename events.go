@@ -0,0 +1,223 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// Kernel event types.
+const (
+	// EventMetrics carries a *KernelMetricsJSON at the end of every
+	// maint timeslice.
+	EventMetrics = "metrics"
+
+	// EventBlockGenerated and EventBlockAdded carry a *BlockEvent.
+	EventBlockGenerated = "block.generated"
+	EventBlockAdded     = "block.added"
+
+	// EventHeadSwitched carries a *HeadSwitchedEvent when block
+	// generation moves to a different competing branch.
+	EventHeadSwitched = "head.switched"
+
+	// EventProcOverrun carries a *ProcOverrunEvent when maintenance
+	// leaves no time for the proc timeslice.
+	EventProcOverrun = "proc.overrun"
+
+	// EventConfigChanged carries a *ConfigChangedEvent when an update
+	// to the configuration takes effect.
+	EventConfigChanged = "config.changed"
+)
+
+// Event is a notification of something that happened in the kernel.
+type Event struct {
+	Type  string      `json:"type"`
+	Cycle uint64      `json:"cycle,string"`
+	Time  int64       `json:"time,string"`
+	Data  interface{} `json:"data"`
+}
+
+type BlockEvent struct {
+	BlockNumber uint64 `json:"blockNumber,string"`
+	Hash        string `json:"hash"`
+	ParentHash  string `json:"parentHash"`
+	Local       bool   `json:"local"`
+}
+
+type HeadSwitchedEvent struct {
+	FromRootID  int    `json:"fromRootID"`
+	ToRootID    int    `json:"toRootID"`
+	BlockNumber uint64 `json:"blockNumber,string"`
+}
+
+type ProcOverrunEvent struct {
+	Overrun time.Duration `json:"overrun"`
+}
+
+// Subscription receives kernel events on C. Events are dropped, not
+// queued without bound, when the subscriber falls behind.
+type Subscription struct {
+	C       <-chan *Event
+	c       chan *Event
+	types   map[string]bool
+	dropped uint64
+}
+
+// eventBufferLen is the number of events a subscription holds before
+// further events are dropped.
+const eventBufferLen = 256
+
+type eventBus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]bool
+}
+
+var events *eventBus
+
+func initEvents() {
+	events = &eventBus{subs: make(map[*Subscription]bool)}
+}
+
+// Subscribe returns a subscription to events of the given types, or to
+// all events if none are given.
+func Subscribe(types ...string) *Subscription {
+	panicIfUninitialized()
+	s := &Subscription{}
+	s.c = make(chan *Event, eventBufferLen)
+	s.C = s.c
+	if len(types) > 0 {
+		s.types = make(map[string]bool)
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+
+	events.mu.Lock()
+	events.subs[s] = true
+	events.mu.Unlock()
+	return s
+}
+
+// Close ends the subscription and closes C.
+func (s *Subscription) Close() {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if events.subs[s] {
+		delete(events.subs, s)
+		close(s.c)
+	}
+}
+
+// Dropped returns the number of events dropped because C was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+func (s *Subscription) wants(eventType string) bool {
+	return s.types == nil || s.types[eventType]
+}
+
+func (b *eventBus) wanted(eventType string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.wants(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+func publish(eventType string, data interface{}) {
+	e := &Event{Type: eventType, Cycle: ktime.CycleNumber(), Time: time.Now().UnixNano(), Data: data}
+
+	events.mu.RLock()
+	defer events.mu.RUnlock()
+	for s := range events.subs {
+		if !s.wants(eventType) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func publishMetrics() {
+	if events.wanted(EventMetrics) {
+		publish(EventMetrics, metrics.Snapshot())
+	}
+}
+
+func newBlockEvent(block spec.Block, local bool) *BlockEvent {
+	return &BlockEvent{
+		BlockNumber: block.BlockNumber(),
+		Hash:        block.Hash(),
+		ParentHash:  block.ParentHash(),
+		Local:       local}
+}
+
+// EventStreamHandler streams kernel events as newline-delimited JSON
+// over a chunked HTTP response until the client disconnects. The
+// "types" query parameter, a comma-separated list of event types,
+// limits the events sent. Callers require RoleReader.
+func EventStreamHandler() http.Handler {
+	return RequireRole(RoleReader, "StreamEvents", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		var types []string
+		if t := r.URL.Query().Get("types"); t != "" {
+			types = strings.Split(t, ",")
+		}
+		sub := Subscribe(types...)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				if err := enc.Encode(e); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}))
+}
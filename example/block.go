@@ -0,0 +1,68 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+// Package example is a minimal in-memory blockchain and hit-rate
+// consensus for the kernel, meant as a reference for implementing the
+// spec interfaces and for simulations.
+package example
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// Block is the block of the example chain. Producer and Cycle make
+// blocks generated by different nodes, or in different cycles, distinct.
+type Block struct {
+	Number   uint64 `json:"number,string"`
+	Parent   string `json:"parent"`
+	Producer string `json:"producer"`
+	Cycle    uint64 `json:"cycle,string"`
+}
+
+var _ spec.Block = (*Block)(nil)
+
+func (b *Block) Marshal() ([]byte, []byte, error) {
+	data, err := json.Marshal(b)
+	return data, nil, err
+}
+
+func (b *Block) Unmarshal(data []byte, links []byte) error {
+	return json.Unmarshal(data, b)
+}
+
+func (b *Block) Hash() string {
+	h := sha256.New()
+	num := make([]byte, 16)
+	binary.BigEndian.PutUint64(num, b.Number)
+	binary.BigEndian.PutUint64(num[8:], b.Cycle)
+	h.Write(num)
+	h.Write([]byte(b.Parent))
+	h.Write([]byte(b.Producer))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (b *Block) ParentHash() string {
+	return b.Parent
+}
+
+func (b *Block) BlockNumber() uint64 {
+	return b.Number
+}
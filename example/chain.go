@@ -0,0 +1,221 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package example
+
+import (
+	"errors"
+	"sync"
+
+	kernel "github.com/blocktop/go-kernel"
+	spec "github.com/blocktop/go-spec"
+)
+
+// treeNode is a block in a node's block tree.
+type treeNode struct {
+	block     *Block
+	parent    *treeNode
+	children  int
+	rootID    int
+	localHits int
+	confirmed bool
+}
+
+// blockTree is the state shared by the blockchain and consensus of one
+// node. Every head of the tree is the tip of a branch,
+// identified by a root ID. Branches that fall forkDepth blocks behind
+// the highest head are abandoned, and blocks confirmDepth below the
+// highest head that are common to all remaining branches are confirmed.
+type blockTree struct {
+	mu           sync.Mutex
+	producer     string
+	forkDepth    uint64
+	confirmDepth uint64
+	nodes        map[string]*treeNode
+	heads        map[int]*treeNode
+	hits         map[int]float64
+	nextRootID   int
+	rootID       int
+	rootHead     *treeNode
+	confirmed    *treeNode
+	forks        int
+	reorgs       []uint64
+	finality     []uint64
+}
+
+const hitDecay = 0.9
+const branchLen = 10
+
+var errUnknownParent = errors.New("parent block is unknown")
+
+func newBlockTree(producer string, forkDepth uint64, confirmDepth uint64) *blockTree {
+	return &blockTree{
+		producer:     producer,
+		forkDepth:    forkDepth,
+		confirmDepth: confirmDepth,
+		nodes:        make(map[string]*treeNode),
+		heads:        make(map[int]*treeNode),
+		hits:         make(map[int]float64),
+		nextRootID:   1,
+		reorgs:       make([]uint64, 0),
+		finality:     make([]uint64, 0)}
+}
+
+func (t *blockTree) add(b *Block, local bool) (*treeNode, error) {
+	hash := b.Hash()
+	if n, ok := t.nodes[hash]; ok {
+		return n, nil
+	}
+
+	n := &treeNode{block: b}
+	if b.Number == 0 && b.Parent == "" {
+		if len(t.nodes) > 0 {
+			return nil, errors.New("a genesis block is already known")
+		}
+		n.confirmed = true
+		t.confirmed = n
+	} else {
+		parent, ok := t.nodes[b.Parent]
+		if !ok {
+			return nil, errUnknownParent
+		}
+		n.parent = parent
+		parent.children++
+	}
+	t.nodes[hash] = n
+
+	rootID := 0
+	if n.parent != nil && t.heads[n.parent.rootID] == n.parent {
+		rootID = n.parent.rootID
+		if local {
+			n.localHits = n.parent.localHits + 1
+		}
+	} else {
+		if n.parent != nil {
+			t.forks++
+		}
+		rootID = t.nextRootID
+		t.nextRootID++
+		if local {
+			n.localHits = 1
+		}
+	}
+	n.rootID = rootID
+	t.heads[rootID] = n
+
+	for id := range t.hits {
+		t.hits[id] *= hitDecay
+	}
+	t.hits[rootID]++
+	return n, nil
+}
+
+// ancestorAt returns the ancestor of n at the given height.
+func ancestorAt(n *treeNode, height uint64) *treeNode {
+	for n != nil && n.block.Number > height {
+		n = n.parent
+	}
+	return n
+}
+
+func commonAncestor(a *treeNode, b *treeNode) *treeNode {
+	if a.block.Number > b.block.Number {
+		a = ancestorAt(a, b.block.Number)
+	} else {
+		b = ancestorAt(b, a.block.Number)
+	}
+	for a != nil && b != nil && a != b {
+		a, b = a.parent, b.parent
+	}
+	return a
+}
+
+// Blockchain is the spec.Blockchain of the example chain.
+type Blockchain struct {
+	tree *blockTree
+}
+
+var _ spec.Blockchain = (*Blockchain)(nil)
+
+// Config sets the fork-choice parameters of the example chain.
+type Config struct {
+	// Producer is recorded in the blocks this node generates.
+	Producer string
+
+	// ForkDepth is the number of blocks a branch may fall behind the
+	// highest branch before it is abandoned. The default is 2.
+	ForkDepth uint64
+
+	// ConfirmDepth is the depth below the highest head at which blocks
+	// common to every branch are confirmed. The default is 3.
+	ConfirmDepth uint64
+}
+
+// New returns the blockchain and consensus of one node. They share the
+// node's block tree.
+func New(c Config) (*Blockchain, *Consensus) {
+	if c.ForkDepth == 0 {
+		c.ForkDepth = 2
+	}
+	if c.ConfirmDepth == 0 {
+		c.ConfirmDepth = 3
+	}
+	t := newBlockTree(c.Producer, c.ForkDepth, c.ConfirmDepth)
+	return &Blockchain{tree: t}, &Consensus{tree: t}
+}
+
+func (c *Blockchain) Name() string {
+	return "example"
+}
+
+func (c *Blockchain) GenerateGenesis() spec.Block {
+	return &Block{Producer: c.tree.producer, Cycle: kernel.Time().CycleNumber()}
+}
+
+func (c *Blockchain) GenerateBlock(branch []spec.Block, rootID int) spec.Block {
+	head := branch[0]
+	return &Block{
+		Number:   head.BlockNumber() + 1,
+		Parent:   head.Hash(),
+		Producer: c.tree.producer,
+		Cycle:    kernel.Time().CycleNumber()}
+}
+
+func (c *Blockchain) AddBlocks(blocks []spec.Block, local bool) *spec.AddBlocksResponse {
+	t := c.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := &spec.AddBlocksResponse{}
+	for _, block := range blocks {
+		b, ok := block.(*Block)
+		if !ok {
+			res.Error = errors.New("block is not an example block")
+			return res
+		}
+		// A known block is not added again, so that it is not
+		// broadcast back to the peers it came from.
+		if _, ok := t.nodes[b.Hash()]; ok {
+			continue
+		}
+		if _, err := t.add(b, local); err != nil {
+			res.Error = err
+			return res
+		}
+		res.AddedBlock = b
+	}
+	return res
+}
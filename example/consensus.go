@@ -0,0 +1,209 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package example
+
+import (
+	kernel "github.com/blocktop/go-kernel"
+	spec "github.com/blocktop/go-spec"
+)
+
+// Branch is a spec.CompetingBranch of the example consensus.
+type Branch struct {
+	blocks    []spec.Block
+	rootID    int
+	hitRate   float64
+	localHits int
+}
+
+var _ spec.CompetingBranch = (*Branch)(nil)
+
+func (b *Branch) Blocks() []spec.Block      { return b.blocks }
+func (b *Branch) RootID() int               { return b.rootID }
+func (b *Branch) HitRate() float64          { return b.hitRate }
+func (b *Branch) ConsecutiveLocalHits() int { return b.localHits }
+
+// Competition is the spec.Competition of the example consensus.
+type Competition struct {
+	branches map[int]spec.CompetingBranch
+}
+
+var _ spec.Competition = (*Competition)(nil)
+
+func (c *Competition) Branches() map[int]spec.CompetingBranch {
+	return c.branches
+}
+
+// Consensus is a spec.Consensus that chooses between branches by hit
+// rate: the decaying count of blocks recently added to each.
+type Consensus struct {
+	tree *blockTree
+}
+
+var _ spec.Consensus = (*Consensus)(nil)
+
+func (c *Consensus) Evaluate() spec.Competition {
+	t := c.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := 0.0
+	for _, h := range t.hits {
+		total += h
+	}
+	comp := &Competition{branches: make(map[int]spec.CompetingBranch)}
+	for rootID, head := range t.heads {
+		blocks := make([]spec.Block, 0, branchLen)
+		for n := head; n != nil && len(blocks) < branchLen; n = n.parent {
+			blocks = append(blocks, n.block)
+		}
+		comp.branches[rootID] = &Branch{
+			blocks:    blocks,
+			rootID:    rootID,
+			hitRate:   t.hits[rootID] / total,
+			localHits: head.localHits}
+	}
+	return comp
+}
+
+func (c *Consensus) ConfirmBlocks() {
+	t := c.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.heads) == 0 {
+		return
+	}
+	var max uint64
+	for _, head := range t.heads {
+		if head.block.Number > max {
+			max = head.block.Number
+		}
+	}
+	for rootID, head := range t.heads {
+		if head.block.Number+t.forkDepth < max {
+			delete(t.heads, rootID)
+			delete(t.hits, rootID)
+		}
+	}
+
+	var common *treeNode
+	for _, head := range t.heads {
+		if common == nil {
+			common = head
+		} else {
+			common = commonAncestor(common, head)
+		}
+	}
+	if common == nil || max < t.confirmDepth {
+		return
+	}
+	common = ancestorAt(common, max-t.confirmDepth)
+
+	cycle := kernel.Time().CycleNumber()
+	for n := common; n != nil && !n.confirmed; n = n.parent {
+		n.confirmed = true
+		t.finality = append(t.finality, cycle-n.block.Cycle)
+	}
+	if common != nil && common.block.Number > t.confirmed.block.Number {
+		t.confirmed = common
+	}
+}
+
+func (c *Consensus) SetConfirmingRoot(rootID int) {
+	t := c.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	head := t.heads[rootID]
+	if head == nil {
+		return
+	}
+	if rootID != t.rootID && t.rootHead != nil {
+		if a := commonAncestor(t.rootHead, head); a != nil && t.rootHead.block.Number > a.block.Number {
+			t.reorgs = append(t.reorgs, t.rootHead.block.Number-a.block.Number)
+		}
+	}
+	t.rootID = rootID
+	t.rootHead = head
+}
+
+// Stats describes the block tree of one node.
+type Stats struct {
+	// Height is the number of the head of the confirming branch.
+	Height uint64
+
+	// ConfirmedHeight and ConfirmedHash identify the highest confirmed
+	// block.
+	ConfirmedHeight uint64
+	ConfirmedHash   string
+
+	// Forks counts blocks added that did not extend a branch head.
+	Forks int
+
+	// ReorgDepths holds, for each switch of the confirming branch, the
+	// number of blocks of the old branch that were abandoned.
+	ReorgDepths []uint64
+
+	// Finality holds, for each block confirmed, the number of cycles
+	// from the cycle it was generated in to the cycle it was confirmed.
+	Finality []uint64
+}
+
+func (c *Consensus) Stats() *Stats {
+	t := c.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &Stats{Forks: t.forks}
+	if t.rootHead != nil {
+		s.Height = t.rootHead.block.Number
+	}
+	if t.confirmed != nil {
+		s.ConfirmedHeight = t.confirmed.block.Number
+		s.ConfirmedHash = t.confirmed.block.Hash()
+	}
+	s.ReorgDepths = append(make([]uint64, 0, len(t.reorgs)), t.reorgs...)
+	s.Finality = append(make([]uint64, 0, len(t.finality)), t.finality...)
+	return s
+}
+
+// Blocks returns every block known to the node.
+func (c *Consensus) Blocks() []*Block {
+	t := c.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	blocks := make([]*Block, 0, len(t.nodes))
+	for _, n := range t.nodes {
+		blocks = append(blocks, n.block)
+	}
+	return blocks
+}
+
+// Chain returns the blocks of the confirming branch, head first, back
+// to genesis.
+func (c *Consensus) Chain() []*Block {
+	t := c.tree
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	blocks := make([]*Block, 0)
+	for n := t.rootHead; n != nil; n = n.parent {
+		blocks = append(blocks, n.block)
+	}
+	return blocks
+}
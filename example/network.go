@@ -0,0 +1,126 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package example
+
+import (
+	"context"
+	"sync"
+
+	kernel "github.com/blocktop/go-kernel"
+	spec "github.com/blocktop/go-spec"
+)
+
+// Node is a spec.NetworkNode on an in-memory network. Broadcasts are
+// placed in the inboxes of the node's peers, and an inbox is passed to
+// the kernel by Deliver. Nodes are meant for kernels hosted in one
+// process and stepped in turn.
+type Node struct {
+	mu       sync.Mutex
+	peerID   string
+	peers    []*Node
+	receiver spec.MessageReceiver
+	inbox    []*spec.NetworkMessage
+}
+
+var _ spec.NetworkNode = (*Node)(nil)
+
+func NewNode(peerID string) *Node {
+	return &Node{peerID: peerID}
+}
+
+// Connect links two nodes so that each receives the other's broadcasts.
+func Connect(a *Node, b *Node) {
+	if a == b {
+		return
+	}
+	for _, p := range a.peers {
+		if p == b {
+			return
+		}
+	}
+	a.peers = append(a.peers, b)
+	b.peers = append(b.peers, a)
+}
+
+func (n *Node) PeerID() string {
+	return n.peerID
+}
+
+// Peers returns the number of nodes connected to n.
+func (n *Node) Peers() int {
+	return len(n.peers)
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() {
+}
+
+// Broadcast places a copy of each message in the inbox of every peer,
+// so that no two kernels share a message or its data.
+func (n *Node) Broadcast(netMsgs []*spec.NetworkMessage) {
+	for _, peer := range n.peers {
+		msgs := make([]*spec.NetworkMessage, len(netMsgs))
+		for i, netMsg := range netMsgs {
+			msgs[i] = copyMessage(netMsg)
+		}
+		peer.mu.Lock()
+		peer.inbox = append(peer.inbox, msgs...)
+		peer.mu.Unlock()
+	}
+}
+
+func copyMessage(netMsg *spec.NetworkMessage) *spec.NetworkMessage {
+	msg := *netMsg
+	if netMsg.Data != nil {
+		msg.Data = append([]byte(nil), netMsg.Data...)
+	}
+	if netMsg.Links != nil {
+		msg.Links = append([]byte(nil), netMsg.Links...)
+	}
+	return &msg
+}
+
+func (n *Node) OnMessageReceived(receiver spec.MessageReceiver) {
+	n.receiver = receiver
+}
+
+// Deliver passes the messages in the inbox to the kernel. The node's
+// kernel instance must be active.
+func (n *Node) Deliver() {
+	n.mu.Lock()
+	msgs := n.inbox
+	n.inbox = nil
+	n.mu.Unlock()
+
+	if n.receiver == nil {
+		return
+	}
+	for _, netMsg := range msgs {
+		n.receiver(netMsg)
+	}
+}
+
+// Step activates the node's kernel instance, delivers the node's inbox
+// to it and runs one cycle of it.
+func (n *Node) Step(ctx context.Context, instance *kernel.Instance) (*kernel.CycleReport, error) {
+	instance.Activate()
+	n.Deliver()
+	return kernel.Step(ctx)
+}
@@ -0,0 +1,90 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+// Command examples boots a genesis node and a follower of the example
+// chain in one process and runs them for a number of cycles, printing
+// the head of each node after every cycle.
+//
+// Each kernel's state is package-wide, so the two kernels are hosted as
+// kernel.Instances and stepped in turn, exchanging messages over an
+// in-memory network.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/example"
+	"github.com/golang/glog"
+)
+
+type node struct {
+	name      string
+	net       *example.Node
+	consensus *example.Consensus
+	instance  *kernel.Instance
+}
+
+func newNode(name string, genesis bool, frequency float64) *node {
+	n := &node{name: name, net: example.NewNode(name)}
+	chain, consensus := example.New(example.Config{Producer: name})
+	n.consensus = consensus
+
+	kernel.Init(&kernel.KernelConfig{
+		Genesis:        genesis,
+		Blockchain:     chain,
+		Consensus:      consensus,
+		BlockFrequency: frequency,
+		BlockPrototype: &example.Block{},
+		NetworkNode:    n.net,
+		MetricsWindows: []int{10}})
+	n.instance = kernel.Current()
+	return n
+}
+
+func main() {
+	cycles := flag.Int("cycles", 20, "number of block cycles to run")
+	frequency := flag.Float64("frequency", 10, "block frequency, in blocks per second")
+	flag.Parse()
+
+	genesis := newNode("genesis", true, *frequency)
+	follower := newNode("follower", false, *frequency)
+	example.Connect(genesis.net, follower.net)
+
+	ctx := context.Background()
+	for c := 0; c < *cycles; c++ {
+		for _, n := range []*node{genesis, follower} {
+			report, err := n.net.Step(ctx, n.instance)
+			if err != nil {
+				glog.Exitln(err)
+			}
+			stats := n.consensus.Stats()
+			generated := "-"
+			if report.GeneratedBlock != nil {
+				generated = fmt.Sprintf("%d:%s", report.GeneratedBlock.BlockNumber(), report.GeneratedBlock.Hash()[:6])
+			}
+			fmt.Printf("cycle %3d  %-8s  generated %-10s  added %d  head %d  confirmed %d\n",
+				report.CycleNumber, n.name, generated, len(report.AddedBlocks), stats.Height, stats.ConfirmedHeight)
+		}
+	}
+
+	for _, n := range []*node{genesis, follower} {
+		n.instance.Activate()
+		kernel.Stop()
+	}
+}
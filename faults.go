@@ -0,0 +1,185 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	spec "github.com/blocktop/go-spec"
+	"github.com/golang/glog"
+)
+
+// FaultKind identifies a point in the block pipeline where a fault can
+// be injected.
+type FaultKind string
+
+const (
+	// FaultDropMessage discards inbound messages before they are queued.
+	FaultDropMessage FaultKind = "drop_message"
+	// FaultDelayMessage holds inbound messages for Delay before they are
+	// queued. A stepped kernel holds them until its next cycle instead.
+	FaultDelayMessage FaultKind = "delay_message"
+	// FaultAddBlocksTimeout makes AddBlocks fail with ErrAddBlocksTimeout
+	// after Delay, without calling the blockchain.
+	FaultAddBlocksTimeout FaultKind = "add_blocks_timeout"
+	// FaultSlowGenerate adds Delay to every GenerateBlock call.
+	FaultSlowGenerate FaultKind = "slow_generate"
+	// FaultStallMaint adds Delay to the maint timeslice.
+	FaultStallMaint FaultKind = "stall_maint"
+)
+
+// ErrAddBlocksTimeout is the error of AddBlocks calls failed by
+// FaultAddBlocksTimeout.
+var ErrAddBlocksTimeout = errors.New("add blocks timed out (injected fault)")
+
+// Fault is one fault to inject during a range of cycles.
+type Fault struct {
+	Kind FaultKind
+
+	// FromCycle and ToCycle bound the cycles, inclusive, in which the
+	// fault is active. A ToCycle of 0 leaves the range open.
+	FromCycle uint64
+	ToCycle   uint64
+
+	// Probability is the chance that each opportunity is faulted. Zero
+	// means always.
+	Probability float64
+
+	// Protocol restricts message faults to one protocol. Empty means
+	// every protocol.
+	Protocol string
+
+	Delay time.Duration
+}
+
+// FaultScript returns additional faults to inject in the given cycle.
+// It must not call the FaultInjector it is set on.
+type FaultScript func(cycle uint64) []Fault
+
+// FaultInjector decides which faults to inject in the block pipeline.
+// It is set on KernelConfig to enable fault injection; faults can be
+// added and cleared while the kernel runs.
+type FaultInjector struct {
+	mu       sync.Mutex
+	rand     *rand.Rand
+	faults   []Fault
+	script   FaultScript
+	injected map[FaultKind]int
+}
+
+var faults *FaultInjector
+
+// NewFaultInjector returns an injector whose probabilistic faults are
+// drawn from a source with the given seed, so runs are repeatable.
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{
+		rand:     rand.New(rand.NewSource(seed)),
+		faults:   make([]Fault, 0),
+		injected: make(map[FaultKind]int)}
+}
+
+func initFaults(c *KernelConfig) {
+	faults = c.Faults
+}
+
+func (f *FaultInjector) Add(fault ...Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, fault...)
+}
+
+// SetScript sets a function consulted every cycle for faults in
+// addition to those added with Add.
+func (f *FaultInjector) SetScript(script FaultScript) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = script
+}
+
+// Clear removes all faults and the script.
+func (f *FaultInjector) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = make([]Fault, 0)
+	f.script = nil
+}
+
+// Injected returns the number of faults injected of each kind.
+func (f *FaultInjector) Injected() map[FaultKind]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := make(map[FaultKind]int, len(f.injected))
+	for k, v := range f.injected {
+		res[k] = v
+	}
+	return res
+}
+
+// inject returns the fault of the given kind to inject now, if any.
+// It is safe to call on a nil injector.
+func (f *FaultInjector) inject(kind FaultKind, protocol string) *Fault {
+	if f == nil {
+		return nil
+	}
+	cycle := ktime.CycleNumber()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	candidates := f.faults
+	if f.script != nil {
+		candidates = append(candidates[:len(candidates):len(candidates)], f.script(cycle)...)
+	}
+	for i := range candidates {
+		fault := &candidates[i]
+		if fault.Kind != kind || cycle < fault.FromCycle || (fault.ToCycle > 0 && cycle > fault.ToCycle) {
+			continue
+		}
+		if fault.Protocol != "" && fault.Protocol != protocol {
+			continue
+		}
+		if fault.Probability > 0 && f.rand.Float64() >= fault.Probability {
+			continue
+		}
+		f.injected[kind]++
+		glog.V(2).Infof("%s: injecting %s fault", ktime.String(), kind)
+		res := *fault
+		return &res
+	}
+	return nil
+}
+
+// stall sleeps for the delay of the fault of the given kind, if one is
+// injected now.
+func (f *FaultInjector) stall(kind FaultKind) {
+	if fault := f.inject(kind, ""); fault != nil && fault.Delay > 0 {
+		time.Sleep(fault.Delay)
+	}
+}
+
+// addBlocks calls AddBlocks on the blockchain unless a timeout fault is
+// injected.
+func (b *KernelBlock) addBlocks(blocks []spec.Block, local bool) *spec.AddBlocksResponse {
+	if fault := faults.inject(FaultAddBlocksTimeout, ""); fault != nil {
+		time.Sleep(fault.Delay)
+		return &spec.AddBlocksResponse{Error: ErrAddBlocksTimeout}
+	}
+	return b.blockchain.AddBlocks(blocks, local)
+}
@@ -0,0 +1,268 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthConfig sets the thresholds of the health checks. Zero and nil
+// fields take the defaults noted.
+type HealthConfig struct {
+	// LivenessIntervals is the number of block intervals within which
+	// the cycle loop must start a new cycle. Default 10.
+	LivenessIntervals int
+
+	// PeerCycles is the number of cycles within which a message from a
+	// peer must have been accepted for the peer to count toward
+	// readiness. Default 100. Senders are authenticated only on
+	// channels with a KeyStore.
+	PeerCycles uint64
+
+	// MinPeers is the number of recently heard peers required for
+	// readiness. It is a pointer so that an explicit 0, for a node that
+	// may be ready without peers, differs from unset. Default 1, or 0 on
+	// a genesis node, which may be the only node of its network.
+	MinPeers *int
+
+	// SyncingBlockQueue is the block queue depth above which the node
+	// is considered to be syncing. Default 1000.
+	SyncingBlockQueue int
+
+	// MaxBlockQueue and MaxReceiveQueue are the queue depths above which
+	// the queue checks fail. Default 10000 each.
+	MaxBlockQueue   int
+	MaxReceiveQueue int
+
+	// AddBlocksErrorCycles is the number of cycles for which an
+	// AddBlocks error fails its check. Default 100.
+	AddBlocksErrorCycles uint64
+}
+
+// HealthCheck is the result of one health check.
+type HealthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// HealthReport gives the liveness and readiness of the node and the
+// checks from which they were determined. Live requires the liveness
+// check; Ready requires every check.
+type HealthReport struct {
+	Live   bool          `json:"live"`
+	Ready  bool          `json:"ready"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+type kernelHealth struct {
+	mu     sync.Mutex
+	config HealthConfig
+}
+
+var health *kernelHealth
+
+func initHealth(c HealthConfig, genesis bool) {
+	health = &kernelHealth{config: healthDefaults(c, genesis)}
+}
+
+// setConfig replaces the thresholds, as when the configuration is
+// updated.
+func (h *kernelHealth) setConfig(c HealthConfig, genesis bool) {
+	c = healthDefaults(c, genesis)
+	h.mu.Lock()
+	h.config = c
+	h.mu.Unlock()
+}
+
+func healthDefaults(c HealthConfig, genesis bool) HealthConfig {
+	if c.LivenessIntervals <= 0 {
+		c.LivenessIntervals = 10
+	}
+	if c.PeerCycles == 0 {
+		c.PeerCycles = 100
+	}
+	if c.MinPeers == nil {
+		minPeers := 1
+		if genesis {
+			minPeers = 0
+		}
+		c.MinPeers = &minPeers
+	}
+	if c.SyncingBlockQueue <= 0 {
+		c.SyncingBlockQueue = 1000
+	}
+	if c.MaxBlockQueue <= 0 {
+		c.MaxBlockQueue = 10000
+	}
+	if c.MaxReceiveQueue <= 0 {
+		c.MaxReceiveQueue = 10000
+	}
+	if c.AddBlocksErrorCycles == 0 {
+		c.AddBlocksErrorCycles = 100
+	}
+	return c
+}
+
+func (c HealthConfig) String() string {
+	minPeers := "default"
+	if c.MinPeers != nil {
+		minPeers = fmt.Sprint(*c.MinPeers)
+	}
+	return fmt.Sprintf("{LivenessIntervals:%d PeerCycles:%d MinPeers:%s SyncingBlockQueue:%d MaxBlockQueue:%d MaxReceiveQueue:%d AddBlocksErrorCycles:%d}",
+		c.LivenessIntervals, c.PeerCycles, minPeers, c.SyncingBlockQueue, c.MaxBlockQueue, c.MaxReceiveQueue, c.AddBlocksErrorCycles)
+}
+
+func Health() *HealthReport {
+	panicIfUninitialized()
+	return health.Report()
+}
+
+func (h *kernelHealth) Report() *HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r := &HealthReport{}
+	live := h.checkLiveness()
+	r.Checks = []HealthCheck{
+		live,
+		h.checkPeers(),
+		h.checkSyncing(),
+		h.checkProcTime(),
+		h.checkBlockQueue(),
+		h.checkReceiveQueues(),
+		h.checkAddBlocks()}
+
+	r.Live = live.OK
+	r.Ready = true
+	for _, c := range r.Checks {
+		r.Ready = r.Ready && c.OK
+	}
+	return r
+}
+
+func (h *kernelHealth) checkLiveness() HealthCheck {
+	c := HealthCheck{Name: "liveness"}
+	if !Started() {
+		c.Detail = "kernel is not started"
+		return c
+	}
+	limit := time.Duration(h.config.LivenessIntervals) * ktime.BlockInterval()
+	since := ktime.cycleOffset()
+	c.OK = since <= limit
+	c.Detail = fmt.Sprintf("cycle %d started %s ago, limit %s", ktime.CycleNumber(), since, limit)
+	return c
+}
+
+func (h *kernelHealth) checkPeers() HealthCheck {
+	peers := metrics.ActivePeers(h.config.PeerCycles)
+	return HealthCheck{
+		Name:   "peers",
+		OK:     peers >= *h.config.MinPeers,
+		Detail: fmt.Sprintf("%d peers heard in the last %d cycles, %d required", peers, h.config.PeerCycles, *h.config.MinPeers)}
+}
+
+func (h *kernelHealth) checkSyncing() HealthCheck {
+	c := HealthCheck{Name: "syncing"}
+	queued := int(metrics.BlockQCount())
+	switch {
+	case blk.BlockNumber() == 0:
+		c.Detail = "no head block yet"
+	case queued > h.config.SyncingBlockQueue:
+		c.Detail = fmt.Sprintf("%d blocks queued, more than %d", queued, h.config.SyncingBlockQueue)
+	default:
+		c.OK = true
+		c.Detail = fmt.Sprintf("generating block %d", blk.BlockNumber())
+	}
+	return c
+}
+
+func (h *kernelHealth) checkProcTime() HealthCheck {
+	last := metrics.ComputedProcTime()
+	avgs := metrics.ComputedProcTimes()
+	recent := last
+	if len(avgs) > 0 {
+		recent = avgs[0]
+	}
+	return HealthCheck{
+		Name:   "proc_time",
+		OK:     last > 0 && recent > 0,
+		Detail: fmt.Sprintf("scheduled proc time %s, recent average %s", time.Duration(last), time.Duration(recent))}
+}
+
+func (h *kernelHealth) checkBlockQueue() HealthCheck {
+	queued := int(metrics.BlockQCount())
+	return HealthCheck{
+		Name:   "block_queue",
+		OK:     queued <= h.config.MaxBlockQueue,
+		Detail: fmt.Sprintf("%d blocks queued, limit %d", queued, h.config.MaxBlockQueue)}
+}
+
+func (h *kernelHealth) checkReceiveQueues() HealthCheck {
+	c := HealthCheck{Name: "receive_queues", OK: true, Detail: "all receive queues within limit"}
+	for protocol, count := range metrics.RecvQCountMap() {
+		if int(count) > h.config.MaxReceiveQueue {
+			c.OK = false
+			c.Detail = fmt.Sprintf("%s has %d messages queued, limit %d", protocol, int(count), h.config.MaxReceiveQueue)
+			break
+		}
+	}
+	return c
+}
+
+func (h *kernelHealth) checkAddBlocks() HealthCheck {
+	c := HealthCheck{Name: "add_blocks", OK: true, Detail: "no AddBlocks errors"}
+	if metrics.AddBlocksErrors() == 0 {
+		return c
+	}
+	last := metrics.LastAddBlocksErrorCycle()
+	ago := ktime.CycleNumber() - last
+	c.OK = ago > h.config.AddBlocksErrorCycles
+	c.Detail = fmt.Sprintf("last AddBlocks error in cycle %d, %d cycles ago", last, ago)
+	return c
+}
+
+// LivenessHandler responds 200 while the cycle loop is advancing and
+// 503 otherwise, with the health report as the body.
+func LivenessHandler() http.Handler {
+	return healthHandler(func(r *HealthReport) bool { return r.Live })
+}
+
+// ReadinessHandler responds 200 when every health check passes and 503
+// otherwise, with the health report as the body.
+func ReadinessHandler() http.Handler {
+	return healthHandler(func(r *HealthReport) bool { return r.Ready })
+}
+
+func healthHandler(ok func(*HealthReport) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !Initialized() {
+			http.Error(w, "kernel not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		report := health.Report()
+		w.Header().Set("Content-Type", "application/json")
+		if !ok(report) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import "sync"
+
+// Names of the kernel timings, used to label their histograms. They
+// match the names of the timings' moving average series.
+const (
+	timingCycle     = SeriesCycle
+	timingMaint     = SeriesMaint
+	timingProc      = SeriesProc
+	timingGenBlock  = SeriesGenBlock
+	timingAddBlock  = SeriesAddBlock
+	timingConfBlock = SeriesConfBlock
+	timingEval      = SeriesEval
+)
+
+var timingNames = []string{timingCycle, timingMaint, timingProc,
+	timingGenBlock, timingAddBlock, timingConfBlock, timingEval}
+
+// timingBuckets are the upper bounds, in seconds, of the timing
+// histogram buckets.
+var timingBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005,
+	0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram counts observations into cumulative buckets in the manner
+// of a Prometheus histogram.
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type histogramSnapshot struct {
+	bounds []float64
+	counts []uint64 // cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	h := &histogram{}
+	h.bounds = bounds
+	h.counts = make([]uint64, len(bounds))
+	return h
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) snapshot() *histogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := &histogramSnapshot{bounds: h.bounds, count: h.count, sum: h.sum}
+	s.counts = make([]uint64, len(h.counts))
+	var c uint64
+	for i, n := range h.counts {
+		c += n
+		s.counts[i] = c
+	}
+	return s
+}
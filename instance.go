@@ -0,0 +1,74 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+// Instance is an initialized kernel. A kernel's state is held in
+// package variables, so a process hosting several kernels, such as a
+// simulator, initializes each in turn, keeps the Instance returned by
+// Current, and activates an instance before delivering messages to its
+// network node and calling Step on it. Only one instance may be active
+// at a time. A stepped kernel leaves no work running when Step returns,
+// so another instance may be activated straight away. Instances hosted
+// this way must not be started with Start.
+type Instance struct {
+	kernel   *Kernel
+	ktime    *KernelTime
+	faults   *FaultInjector
+	events   *eventBus
+	metrics  *KernelMetrics
+	tracer   *blockTracer
+	net      *KernelNet
+	blk      *KernelBlock
+	proc     *KernelProc
+	reporter *cycleReporter
+	health   *kernelHealth
+	access   *rpcAccess
+}
+
+// Current returns the active instance.
+func Current() *Instance {
+	panicIfUninitialized()
+	return &Instance{
+		kernel:   kernel,
+		ktime:    ktime,
+		faults:   faults,
+		events:   events,
+		metrics:  metrics,
+		tracer:   tracer,
+		net:      net,
+		blk:      blk,
+		proc:     proc,
+		reporter: reporter,
+		health:   health,
+		access:   access}
+}
+
+// Activate makes the instance the one the package functions act on.
+func (i *Instance) Activate() {
+	kernel = i.kernel
+	ktime = i.ktime
+	faults = i.faults
+	events = i.events
+	metrics = i.metrics
+	tracer = i.tracer
+	net = i.net
+	blk = i.blk
+	proc = i.proc
+	reporter = i.reporter
+	health = i.health
+	access = i.access
+}
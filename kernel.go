@@ -18,6 +18,10 @@ package kernel
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	spec "github.com/blocktop/go-spec"
@@ -25,10 +29,25 @@ import (
 )
 
 type Kernel struct {
-	name    string
-	net     spec.NetworkNode
-	started bool
-	stop    func()
+	name     string
+	net      spec.NetworkNode
+	started  int32 // atomic
+	stepping int32 // atomic
+
+	// runMu serializes Start, Stop and Step. The cycle loop of a run
+	// closes loopDone when it exits; Start waits on lastLoop so that
+	// the loops of two runs never overlap.
+	runMu    sync.Mutex
+	ctx      context.Context
+	stop     func()
+	loopDone chan struct{}
+	lastLoop chan struct{}
+
+	mu             sync.Mutex
+	config         *KernelConfig
+	initConfig     *KernelConfig
+	pendingConfig  *KernelConfig
+	pendingChanges []ConfigChange
 }
 
 var kernel *Kernel
@@ -36,20 +55,32 @@ var initHanlders []func() = make([]func(), 0)
 
 func Init(c *KernelConfig) {
 
-	if !c.valid() {
-		panic("all KernelConfig fields are required")
+	if err := c.Validate(); err != nil {
+		panic(err)
 	}
+	c = c.withDefaults()
+	glog.Infof("kernel config:\n%s", c)
 
 	k := &Kernel{}
 	k.name = c.Blockchain.Name()
+	k.config = c
+	k.initConfig = c
+	setLogVerbosity(c.LogVerbosity)
 
 	kernel = k
 
 	initTime(c.BlockFrequency)
-	initMetrics()
-	initNet(c.NetworkNode)
+	initFaults(c)
+	initEvents()
+	initMetrics(c)
+	initTrace(c)
+	initNet(c)
 	initBlock(c)
 	initProc()
+	initCycleReporter()
+	initHealth(c.Health, c.Genesis)
+	initRPCAccess(c)
+	k.restoreState()
 
 	for _, handler := range initHanlders {
 		handler()
@@ -96,40 +127,114 @@ func Block() *KernelBlock {
 }
 
 func Started() bool {
-	return kernel.started
+	return atomic.LoadInt32(&kernel.started) == 1
 }
 
+func (k *Kernel) isStepping() bool {
+	return atomic.LoadInt32(&k.stepping) == 1
+}
+
+// Start runs the block cycle loop until Stop is called or the context
+// is done. If the loop of a previous run is still finishing its cycle,
+// Start waits for it to exit.
 func Start(parentCtx context.Context) {
 	panicIfUninitialized()
-	if kernel.started {
-		return
+	k := kernel
+	k.runMu.Lock()
+	defer k.runMu.Unlock()
+
+	if k.loopDone != nil {
+		select {
+		case <-k.loopDone:
+			// the loop exited because its context is done
+			k.halt()
+		default:
+			return
+		}
+	}
+	if k.lastLoop != nil {
+		<-k.lastLoop
+		k.lastLoop = nil
 	}
 
 	ctx, cancel := context.WithCancel(parentCtx)
-	kernel.stop = cancel
-
-	kernel.started = true
-
+	k.ctx = parentCtx
+	k.stop = cancel
+	done := make(chan struct{})
+	k.loopDone = done
+
+	atomic.StoreInt32(&k.started, 1)
+
+	// A stepped kernel hands what it holds to the queues and keeps its
+	// uptime.
+	if k.isStepping() {
+		k.endStep()
+	} else {
+		ktime.up()
+		net.endStep()
+	}
 	net.start()
 
-	ktime.up()
-
-	go kernel.runBlockCycle(ctx)
+	go k.runBlockCycle(ctx, done)
 }
 
+// Stop ends the cycle loop after its current cycle.
 func Stop() {
 	panicIfUninitialized()
-	if !kernel.started {
+	k := kernel
+	k.runMu.Lock()
+	defer k.runMu.Unlock()
+
+	if k.isStepping() {
+		k.endStep()
+		net.stop()
+		return
+	}
+	if k.loopDone == nil {
 		return
 	}
+	k.halt()
+}
 
+func (k *Kernel) endStep() {
+	net.endStep()
+	blk.blockQs.endStep()
+	atomic.StoreInt32(&k.stepping, 0)
+}
+
+// restart starts the kernel again under the context of its last Start.
+func restart() error {
+	kernel.runMu.Lock()
+	ctx := kernel.ctx
+	kernel.runMu.Unlock()
+	if ctx == nil {
+		return errors.New("kernel has not been started")
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("kernel context is done: %v", err)
+	}
+	Start(ctx)
+	return nil
+}
+
+// halt stops the current run. It is called with runMu held.
+func (k *Kernel) halt() {
 	net.stop()
+	k.stop()
+	k.stop = nil
+	k.lastLoop = k.loopDone
+	k.loopDone = nil
+	atomic.StoreInt32(&k.started, 0)
+}
 
-	if kernel.stop != nil {
-		kernel.stop()
-		kernel.stop = nil
+// loopExited stops the run of the loop that closed done, unless the run
+// has already been stopped.
+func (k *Kernel) loopExited(done chan struct{}) {
+	k.runMu.Lock()
+	defer k.runMu.Unlock()
+	if k.loopDone == done {
+		k.halt()
 	}
-	kernel.started = false
 }
 
 const (
@@ -137,22 +242,22 @@ const (
 	blockCycleStateProc
 )
 
-func (k *Kernel) runBlockCycle(ctx context.Context) {
+func (k *Kernel) runBlockCycle(ctx context.Context, done chan struct{}) {
+	defer close(done)
 	state := blockCycleStateProc
 
 	for {
 		select {
 		case <-ctx.Done():
-			Stop()
+			go k.loopExited(done)
 			return
 		default:
 			switch state {
 			case blockCycleStateProc:
-				ktime.startCycle()
-				k.proc()
+				k.beginCycle()
 				state = blockCycleStateMaint
 			case blockCycleStateMaint:
-				k.maint()
+				k.endCycle()
 				state = blockCycleStateProc
 			}
 		}
@@ -168,11 +273,23 @@ func (k *Kernel) maint() {
 
 	blk.stop()
 	blk.maint()
+	faults.stall(FaultStallMaint)
 
 	net.setMetrics()
+	metrics.evictPeers()
+	net.flushRecorder()
+	net.maintVersions()
+	tracer.maint()
+	k.applyConfig()
+	ktime.maint()
+	k.saveState()
 
 	maintEndTime := time.Now().UnixNano()
 	metrics.setMaintTime(maintEndTime - maintStartTime)
+	reporter.update(func(c *CycleReport) { c.MaintTime = time.Duration(maintEndTime - maintStartTime) })
+
+	metrics.record()
+	publishMetrics()
 }
 
 func (k *Kernel) proc() {
@@ -194,6 +311,8 @@ func (k *Kernel) proc() {
 	procTime := metrics.computeProcTime()
 	glog.V(3).Infof("%s: computed process time %dms", ktime.String(), procTime/time.Millisecond)
 
+	reporter.update(func(c *CycleReport) { c.ComputedProcTime = procTime })
+
 	timer := time.NewTimer(procTime)
 
 	blk.generate()
@@ -209,4 +328,5 @@ func (k *Kernel) proc() {
 	glog.V(3).Infof("%s: actual process time %dms", ktime.String(), actualProcTime/int64(time.Millisecond))
 
 	metrics.setActualProcTime(actualProcTime)
+	reporter.update(func(c *CycleReport) { c.ProcTime = time.Duration(actualProcTime) })
 }
@@ -0,0 +1,67 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kerneltest
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// Block is a spec.Block whose content is a number, a parent hash and an
+// arbitrary payload.
+type Block struct {
+	Number  uint64 `json:"number,string"`
+	Parent  string `json:"parent"`
+	Payload []byte `json:"payload"`
+}
+
+var _ spec.Block = (*Block)(nil)
+
+func NewBlock(number uint64, parent string, payload []byte) *Block {
+	return &Block{Number: number, Parent: parent, Payload: payload}
+}
+
+func (b *Block) Marshal() ([]byte, []byte, error) {
+	data, err := json.Marshal(b)
+	return data, nil, err
+}
+
+func (b *Block) Unmarshal(data []byte, links []byte) error {
+	return json.Unmarshal(data, b)
+}
+
+func (b *Block) Hash() string {
+	h := sha256.New()
+	num := make([]byte, 8)
+	binary.BigEndian.PutUint64(num, b.Number)
+	h.Write(num)
+	h.Write([]byte(b.Parent))
+	h.Write(b.Payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (b *Block) ParentHash() string {
+	return b.Parent
+}
+
+func (b *Block) BlockNumber() uint64 {
+	return b.Number
+}
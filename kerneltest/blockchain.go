@@ -0,0 +1,123 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kerneltest
+
+import (
+	"sync"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// Blockchain is a spec.Blockchain that records the blocks it is given
+// and returns injected results from AddBlocks.
+type Blockchain struct {
+	mu        sync.Mutex
+	name      string
+	results   []*spec.AddBlocksResponse
+	added     []AddBlocksCall
+	generated []spec.Block
+
+	// GenerateFunc, when set, replaces the default block generator,
+	// which makes an empty child of the head of the branch.
+	GenerateFunc func(branch []spec.Block, rootID int) spec.Block
+}
+
+var _ spec.Blockchain = (*Blockchain)(nil)
+
+// AddBlocksCall records one call to AddBlocks.
+type AddBlocksCall struct {
+	Blocks []spec.Block
+	Local  bool
+}
+
+func NewBlockchain(name string) *Blockchain {
+	return &Blockchain{name: name}
+}
+
+func (c *Blockchain) Name() string {
+	return c.name
+}
+
+func (c *Blockchain) GenerateGenesis() spec.Block {
+	block := NewBlock(0, "", nil)
+	c.mu.Lock()
+	c.generated = append(c.generated, block)
+	c.mu.Unlock()
+	return block
+}
+
+func (c *Blockchain) GenerateBlock(branch []spec.Block, rootID int) spec.Block {
+	var block spec.Block
+	if c.GenerateFunc != nil {
+		block = c.GenerateFunc(branch, rootID)
+	} else {
+		head := branch[0]
+		block = NewBlock(head.BlockNumber()+1, head.Hash(), nil)
+	}
+	c.mu.Lock()
+	c.generated = append(c.generated, block)
+	c.mu.Unlock()
+	return block
+}
+
+// InjectAddBlocks queues results to be returned by successive calls to
+// AddBlocks. When no results are queued, AddBlocks reports the last of
+// the blocks it was given as added.
+func (c *Blockchain) InjectAddBlocks(results ...*spec.AddBlocksResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = append(c.results, results...)
+}
+
+// InjectAddBlocksError queues a result carrying err.
+func (c *Blockchain) InjectAddBlocksError(err error) {
+	c.InjectAddBlocks(&spec.AddBlocksResponse{Error: err})
+}
+
+func (c *Blockchain) AddBlocks(blocks []spec.Block, local bool) *spec.AddBlocksResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.added = append(c.added, AddBlocksCall{Blocks: blocks, Local: local})
+	if len(c.results) > 0 {
+		res := c.results[0]
+		c.results = c.results[1:]
+		return res
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	return &spec.AddBlocksResponse{AddedBlock: blocks[len(blocks)-1]}
+}
+
+// AddBlocksCalls returns the calls made to AddBlocks, in order.
+func (c *Blockchain) AddBlocksCalls() []AddBlocksCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	calls := make([]AddBlocksCall, len(c.added))
+	copy(calls, c.added)
+	return calls
+}
+
+// Generated returns the blocks generated, in order.
+func (c *Blockchain) Generated() []spec.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	blocks := make([]spec.Block, len(c.generated))
+	copy(blocks, c.generated)
+	return blocks
+}
@@ -0,0 +1,148 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kerneltest
+
+import (
+	"sync"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// Branch is a spec.CompetingBranch with fixed content. Blocks are
+// ordered newest first, as the kernel expects.
+type Branch struct {
+	Root      int
+	Chain     []spec.Block
+	Rate      float64
+	LocalHits int
+}
+
+var _ spec.CompetingBranch = (*Branch)(nil)
+
+func (b *Branch) Blocks() []spec.Block {
+	return b.Chain
+}
+
+func (b *Branch) RootID() int {
+	return b.Root
+}
+
+func (b *Branch) HitRate() float64 {
+	return b.Rate
+}
+
+func (b *Branch) ConsecutiveLocalHits() int {
+	return b.LocalHits
+}
+
+// Competition is a spec.Competition over a fixed set of branches.
+type Competition struct {
+	Set map[int]spec.CompetingBranch
+}
+
+var _ spec.Competition = (*Competition)(nil)
+
+// NewCompetition returns a competition of the branches keyed by their
+// root IDs.
+func NewCompetition(branches ...*Branch) *Competition {
+	c := &Competition{Set: make(map[int]spec.CompetingBranch)}
+	for _, b := range branches {
+		c.Set[b.Root] = b
+	}
+	return c
+}
+
+func (c *Competition) Branches() map[int]spec.CompetingBranch {
+	return c.Set
+}
+
+// Consensus is a scriptable spec.Consensus. Each call to Evaluate
+// returns the next competition of the script; once the script is
+// exhausted the last competition is repeated.
+type Consensus struct {
+	mu              sync.Mutex
+	script          []spec.Competition
+	evaluations     int
+	confirmations   int
+	confirmingRoots []int
+}
+
+var _ spec.Consensus = (*Consensus)(nil)
+
+func NewConsensus() *Consensus {
+	return &Consensus{}
+}
+
+// Script sets the competitions returned by successive calls to
+// Evaluate.
+func (c *Consensus) Script(comps ...spec.Competition) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.script = comps
+	c.evaluations = 0
+}
+
+func (c *Consensus) ConfirmBlocks() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirmations++
+}
+
+func (c *Consensus) Evaluate() spec.Competition {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.script) == 0 {
+		return nil
+	}
+	i := c.evaluations
+	if i >= len(c.script) {
+		i = len(c.script) - 1
+	}
+	c.evaluations++
+	return c.script[i]
+}
+
+func (c *Consensus) SetConfirmingRoot(rootID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirmingRoots = append(c.confirmingRoots, rootID)
+}
+
+// Evaluations returns the number of calls to Evaluate.
+func (c *Consensus) Evaluations() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evaluations
+}
+
+// Confirmations returns the number of calls to ConfirmBlocks.
+func (c *Consensus) Confirmations() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.confirmations
+}
+
+// ConfirmingRoots returns the root IDs passed to SetConfirmingRoot, in
+// order.
+func (c *Consensus) ConfirmingRoots() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	roots := make([]int, len(c.confirmingRoots))
+	copy(roots, c.confirmingRoots)
+	return roots
+}
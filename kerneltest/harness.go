@@ -0,0 +1,85 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+// Package kerneltest provides fakes of the spec interfaces consumed by
+// the kernel, and a harness that runs kernel cycles against them, for
+// testing blockchains built on the kernel.
+package kerneltest
+
+import (
+	"context"
+
+	kernel "github.com/blocktop/go-kernel"
+)
+
+// Harness wires the fakes into a kernel configuration.
+type Harness struct {
+	Blockchain *Blockchain
+	Consensus  *Consensus
+	Network    *NetworkNode
+	Faults     *kernel.FaultInjector
+	Config     *kernel.KernelConfig
+}
+
+// DefaultBlockFrequency is the block frequency of harness kernels,
+// fast enough that tests run many cycles quickly.
+var DefaultBlockFrequency float64 = 100
+
+func NewHarness(name string, peerID string) *Harness {
+	h := &Harness{}
+	h.Blockchain = NewBlockchain(name)
+	h.Consensus = NewConsensus()
+	h.Network = NewNetworkNode(peerID)
+	h.Faults = kernel.NewFaultInjector(1)
+	h.Config = &kernel.KernelConfig{
+		Blockchain:     h.Blockchain,
+		Consensus:      h.Consensus,
+		BlockFrequency: DefaultBlockFrequency,
+		BlockPrototype: &Block{},
+		NetworkNode:    h.Network,
+		Faults:         h.Faults,
+		MetricsWindows: []int{10, 100}}
+	return h
+}
+
+// Init initializes the kernel with the harness configuration, replacing
+// any kernel initialized before.
+func (h *Harness) Init() {
+	kernel.Init(h.Config)
+}
+
+// Step runs one kernel cycle synchronously.
+func (h *Harness) Step(ctx context.Context) (*kernel.CycleReport, error) {
+	return kernel.Step(ctx)
+}
+
+// RunCycles runs n kernel cycles synchronously and stops the kernel's
+// queues afterwards.
+func (h *Harness) RunCycles(ctx context.Context, n int) ([]*kernel.CycleReport, error) {
+	defer kernel.Stop()
+	return kernel.RunCycles(ctx, n)
+}
+
+// Deliver marshals the block into a message of the kernel's block
+// protocol from the given peer and delivers it to the kernel.
+func (h *Harness) Deliver(block *Block, from string) error {
+	netMsg, err := kernel.Block().PeerMessage(block, from)
+	if err != nil {
+		return err
+	}
+	h.Network.Deliver(netMsg)
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kerneltest_test
+
+import (
+	"context"
+	"testing"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/kerneltest"
+)
+
+func TestDeliverAddsPeerBlock(t *testing.T) {
+	h := kerneltest.NewHarness("test", "node")
+	h.Init()
+	defer kernel.Stop()
+
+	block := kerneltest.NewBlock(1, "parent", []byte("payload"))
+	if err := h.Deliver(block, "peer1"); err != nil {
+		t.Fatal(err)
+	}
+	for protocol, stats := range kernel.Metrics().CompressionStatsMap() {
+		if stats.OutboundRawBytes != 0 {
+			t.Errorf("delivery counted %d outbound bytes on %s", stats.OutboundRawBytes, protocol)
+		}
+	}
+
+	report, err := h.Step(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.AddedBlocks) != 1 || report.AddedBlocks[0].Hash() != block.Hash() {
+		t.Fatalf("added blocks %v, want the delivered block", report.AddedBlocks)
+	}
+
+	calls := h.Blockchain.AddBlocksCalls()
+	if len(calls) != 1 || calls[0].Local {
+		t.Fatalf("AddBlocks calls %+v, want one call for a peer block", calls)
+	}
+
+	traffic := kernel.Metrics().PeerTraffic("peer1")
+	if traffic.MessagesIn != 1 {
+		t.Errorf("peer1 sent %d messages, want 1", traffic.MessagesIn)
+	}
+	if n := kernel.Metrics().ActivePeers(10); n != 1 {
+		t.Errorf("%d active peers, want 1", n)
+	}
+
+	var relayed bool
+	for _, netMsg := range h.Network.Broadcasts() {
+		if netMsg.Hash == block.Hash() {
+			relayed = netMsg.From == "peer1"
+		}
+	}
+	if !relayed {
+		t.Error("added block was not relayed as sent by peer1")
+	}
+}
+
+func TestStepGeneratesGenesis(t *testing.T) {
+	h := kerneltest.NewHarness("test", "node")
+	h.Config.Genesis = true
+	h.Init()
+	defer kernel.Stop()
+
+	reports, err := h.RunCycles(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reports[0].GeneratedBlock == nil || reports[0].GeneratedBlock.BlockNumber() != 0 {
+		t.Fatalf("first cycle generated %v, want the genesis block", reports[0].GeneratedBlock)
+	}
+	if n := len(h.Blockchain.Generated()); n != 1 {
+		t.Errorf("generated %d blocks without a competition, want 1", n)
+	}
+	if h.Consensus.Confirmations() != 2 {
+		t.Errorf("%d confirmations, want one per cycle", h.Consensus.Confirmations())
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kerneltest
+
+import (
+	"context"
+	"sync"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// NetworkNode is a spec.NetworkNode that records broadcasts instead of
+// sending them. Inbound messages are injected with Deliver.
+type NetworkNode struct {
+	mu         sync.Mutex
+	peerID     string
+	receiver   spec.MessageReceiver
+	broadcasts []*spec.NetworkMessage
+}
+
+var _ spec.NetworkNode = (*NetworkNode)(nil)
+
+func NewNetworkNode(peerID string) *NetworkNode {
+	return &NetworkNode{peerID: peerID}
+}
+
+func (n *NetworkNode) PeerID() string {
+	return n.peerID
+}
+
+func (n *NetworkNode) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *NetworkNode) Stop() {
+}
+
+func (n *NetworkNode) Broadcast(netMsgs []*spec.NetworkMessage) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.broadcasts = append(n.broadcasts, netMsgs...)
+}
+
+func (n *NetworkNode) OnMessageReceived(receiver spec.MessageReceiver) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.receiver = receiver
+}
+
+// Deliver passes a message to the kernel as if it had arrived from the
+// network.
+func (n *NetworkNode) Deliver(netMsg *spec.NetworkMessage) {
+	n.mu.Lock()
+	receiver := n.receiver
+	n.mu.Unlock()
+	if receiver != nil {
+		receiver(netMsg)
+	}
+}
+
+// Broadcasts returns the messages broadcast so far, in order.
+func (n *NetworkNode) Broadcasts() []*spec.NetworkMessage {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	msgs := make([]*spec.NetworkMessage, len(n.broadcasts))
+	copy(msgs, n.broadcasts)
+	return msgs
+}
+
+// Reset discards the recorded broadcasts.
+func (n *NetworkNode) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.broadcasts = nil
+}
@@ -17,46 +17,293 @@
 package kernel
 
 import (
+	"errors"
+	"fmt"
 	"reflect"
+	"sort"
 
 	spec "github.com/blocktop/go-spec"
+	"golang.org/x/crypto/ed25519"
 )
 
 type MessageChannel struct {
 	Prototype      spec.Marshalled
 	Protocol       *spec.MessageProtocol
 	ReceiveHandler spec.MessageReceiver
+
+	// Compression is the codec applied to outbound message data.
+	Compression Codec
+
+	// MaxDecodedSize limits the size of inbound message data once
+	// decompressed, and of inbound message links. When zero,
+	// MaxBroadcastSize applies.
+	MaxDecodedSize int
+
+	// KeyStore, when set, signs outbound messages with the node key and
+	// rejects inbound messages not signed by the peer in their From field.
+	KeyStore KeyStore
+
+	envelopeProtocol *spec.MessageProtocol
+	versions         []uint16
+	converters       map[versionStep]VersionConverter
 }
 
+// VersionConverter translates marshalled message data from one version
+// of a channel's format to an adjacent version.
+type VersionConverter func(data []byte, links []byte) ([]byte, []byte, error)
+
+type versionStep struct {
+	from uint16
+	to   uint16
+}
+
+// baseVersion is the format version of channels that have not
+// registered any others.
+const baseVersion uint16 = 1
+
 func NewMessageChannel(prototype spec.Marshalled, receiveHandler spec.MessageReceiver) *MessageChannel {
 	c := &MessageChannel{}
 	c.Prototype = prototype
 	c.Protocol = spec.NewProtocolMarshalled(kernel.name, prototype)
+	c.envelopeProtocol = spec.NewProtocolMarshalled(kernel.name+envelopeProtocolSuffix, prototype)
 	c.ReceiveHandler = receiveHandler
+	c.versions = []uint16{baseVersion}
+	c.converters = make(map[versionStep]VersionConverter)
 	return c
 }
 
+// SetVersions registers the format versions the channel supports. The
+// highest version is the format of the channel's Prototype. Messages in
+// the other versions are translated by converters registered with
+// AddConverter.
+func (c *MessageChannel) SetVersions(versions ...uint16) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("%s: at least one version is required", c.Protocol.String())
+	}
+	vs := make([]uint16, 0, len(versions))
+	seen := make(map[uint16]bool)
+	for _, v := range versions {
+		if v == 0 {
+			return fmt.Errorf("%s: version 0 is reserved", c.Protocol.String())
+		}
+		if !seen[v] {
+			seen[v] = true
+			vs = append(vs, v)
+		}
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i] < vs[j] })
+	c.versions = vs
+	return nil
+}
+
+// AddConverter registers a converter from one version to an adjacent
+// version. Converters are chained to reach versions further apart.
+func (c *MessageChannel) AddConverter(from uint16, to uint16, converter VersionConverter) error {
+	if from+1 != to && to+1 != from {
+		return fmt.Errorf("%s: converter from version %d to %d does not join adjacent versions", c.Protocol.String(), from, to)
+	}
+	c.converters[versionStep{from, to}] = converter
+	return nil
+}
+
+// Version returns the format version of the channel's Prototype.
+func (c *MessageChannel) Version() uint16 {
+	return c.versions[len(c.versions)-1]
+}
+
+func (c *MessageChannel) Versions() []uint16 {
+	vs := make([]uint16, len(c.versions))
+	copy(vs, c.versions)
+	return vs
+}
+
+func (c *MessageChannel) supports(version uint16) bool {
+	for _, v := range c.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *MessageChannel) convert(data []byte, links []byte, from uint16, to uint16) ([]byte, []byte, error) {
+	for from != to {
+		next := from + 1
+		if to < from {
+			next = from - 1
+		}
+		converter, ok := c.converters[versionStep{from, next}]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: no converter from version %d to %d", c.Protocol.String(), from, next)
+		}
+		var err error
+		data, links, err = converter(data, links)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: failed to convert version %d to %d: %v", c.Protocol.String(), from, next, err)
+		}
+		from = next
+	}
+	return data, links, nil
+}
+
+func checkPrototype(prototype spec.Marshalled) error {
+	if prototype == nil {
+		return errors.New("message channel prototype is nil")
+	}
+	v := reflect.ValueOf(prototype)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("message channel prototype %T is not a non-nil pointer", prototype)
+	}
+	return nil
+}
+
+func (c *MessageChannel) maxDecodedSize() int {
+	if c.MaxDecodedSize > 0 {
+		return c.MaxDecodedSize
+	}
+	return MaxBroadcastSize
+}
+
 func (c *MessageChannel) marshal(item spec.Marshalled) (*spec.NetworkMessage, error) {
+	netMsg, err := c.message(item)
+	if err != nil {
+		return nil, err
+	}
+	return c.seal(netMsg)
+}
+
+// message returns the item as a bare message of the channel, as passed
+// to KernelNet.Broadcast.
+func (c *MessageChannel) message(item spec.Marshalled) (*spec.NetworkMessage, error) {
 	data, links, err := item.Marshal()
 	if err != nil {
 		return nil, err
 	}
+	return &spec.NetworkMessage{
+		Data:     data,
+		Links:    links,
+		Hash:     item.Hash(),
+		Protocol: c.Protocol,
+		From:     net.PeerID()}, nil
+}
 
-	netMsg := &spec.NetworkMessage{
+// seal returns a copy of the message with its data, in the format of
+// the channel's Prototype, converted to the broadcast version and placed
+// in an envelope.
+func (c *MessageChannel) seal(netMsg *spec.NetworkMessage) (*spec.NetworkMessage, error) {
+	var key ed25519.PrivateKey
+	if c.KeyStore != nil {
+		key = c.KeyStore.NodeKey()
+	}
+	sealed, size, compressed, err := c.encode(netMsg, key)
+	if err != nil {
+		return nil, err
+	}
+	metrics.addOutboundBytes(c.Protocol.String(), size, compressed)
+	return sealed, nil
+}
+
+// PeerMessage encodes item as the given peer would send it on the
+// channel, signed with key if the channel has a KeyStore. It is meant
+// for tests that deliver messages from simulated peers, and counts no
+// outbound traffic.
+func (c *MessageChannel) PeerMessage(item spec.Marshalled, from string, key ed25519.PrivateKey) (*spec.NetworkMessage, error) {
+	data, links, err := item.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	sealed, _, _, err := c.encode(&spec.NetworkMessage{
 		Data:     data,
 		Links:    links,
 		Hash:     item.Hash(),
 		Protocol: c.Protocol,
-		From:     kernel.net.PeerID()}
+		From:     from}, key)
+	if err != nil {
+		return nil, err
+	}
+	return net.toWire(sealed), nil
+}
+
+// encode seals the message, signing it with key if the channel has a
+// KeyStore, and also returns the size of its data before and after
+// compression.
+func (c *MessageChannel) encode(netMsg *spec.NetworkMessage, key ed25519.PrivateKey) (*spec.NetworkMessage, int, int, error) {
+	version := net.broadcastVersion(c)
+	data, links, err := c.convert(netMsg.Data, netMsg.Links, c.Version(), version)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	codec := c.Compression
+	if !net.useEnvelope(c) {
+		codec = CodecNone
+	}
+	payload, err := compress(codec, data)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("%s: failed to compress message: %v", c.Protocol.String(), err)
+	}
+
+	env := &envelope{version: version, flags: byte(codec) & flagCodecMask, payload: payload}
 
-	return netMsg, nil
+	sealed := *netMsg
+	sealed.Links = links
+	if c.KeyStore != nil {
+		if err := c.sign(&sealed, env, key); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	sealed.Data = env.encode()
+
+	return &sealed, len(data), len(payload), nil
 }
 
 func (c *MessageChannel) unmarshal(netMsg *spec.NetworkMessage) (spec.Marshalled, error) {
-	var item spec.Marshalled = reflect.New(reflect.ValueOf(c.Prototype).Elem().Type()).Interface().(spec.Marshalled)
-	err := item.Unmarshal(netMsg.Data, netMsg.Links)
+	item, size, err := c.parse(netMsg)
 	if err != nil {
 		return nil, err
 	}
+	metrics.addInboundBytes(c.Protocol.String(), len(netMsg.Data), size)
+	if item.Hash() == netMsg.Hash {
+		metrics.addAccepted(netMsg)
+	}
 	return item, nil
 }
+
+// parse opens the message envelope and unmarshals the payload into a
+// new instance of the prototype. It also returns the decompressed size
+// of the payload.
+func (c *MessageChannel) parse(netMsg *spec.NetworkMessage) (spec.Marshalled, int, error) {
+	limit := c.maxDecodedSize()
+	if len(netMsg.Data) > limit+envelopeHeaderLen+ed25519.SignatureSize {
+		return nil, 0, fmt.Errorf("%s: message size %d exceeds limit of %d bytes", c.Protocol.String(), len(netMsg.Data), limit)
+	}
+	if len(netMsg.Links) > limit {
+		return nil, 0, fmt.Errorf("%s: message links size %d exceeds limit of %d bytes", c.Protocol.String(), len(netMsg.Links), limit)
+	}
+
+	env, err := decodeEnvelope(netMsg.Data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !c.supports(env.version) {
+		return nil, 0, fmt.Errorf("%s: unsupported message version %d", c.Protocol.String(), env.version)
+	}
+	payload, err := decompress(Codec(env.flags&flagCodecMask), env.payload, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: failed to decompress message: %v", c.Protocol.String(), err)
+	}
+	data, links, err := c.convert(payload, netMsg.Links, env.version, c.Version())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	item := c.newItem()
+	err = item.Unmarshal(data, links)
+	if err != nil {
+		return nil, 0, err
+	}
+	return item, len(payload), nil
+}
+
+func (c *MessageChannel) newItem() spec.Marshalled {
+	return reflect.New(reflect.ValueOf(c.Prototype).Elem().Type()).Interface().(spec.Marshalled)
+}
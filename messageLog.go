@@ -0,0 +1,205 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// RecordedMessage is an inbound message as written to a message log,
+// with the cycle it arrived in and its offset from the start of that
+// cycle.
+type RecordedMessage struct {
+	Cycle    uint64
+	Offset   time.Duration
+	Protocol string
+	Hash     string
+	From     string
+	Data     []byte
+	Links    []byte
+}
+
+// MessageRecorder appends every inbound message to a log file. The file
+// begins with messageLogMagic and the recording node's peer ID, followed
+// by one record per message. Every field of a record is a uvarint, or a
+// uvarint length followed by that many bytes:
+//
+//	cycle, offset (ns), protocol, hash, from, data, links
+type MessageRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	err  error
+}
+
+const messageLogMagic = "BKML"
+
+// OpenMessageRecorder creates, or truncates, the log file at path.
+func OpenMessageRecorder(path string, peerID string) (*MessageRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &MessageRecorder{file: f, w: bufio.NewWriter(f)}
+	r.w.WriteString(messageLogMagic)
+	r.writeBytes([]byte(peerID))
+	return r, nil
+}
+
+func (r *MessageRecorder) record(netMsg *spec.NetworkMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil || r.err != nil {
+		return
+	}
+	protocol := ""
+	if netMsg.Protocol != nil {
+		protocol = netMsg.Protocol.String()
+	}
+	r.writeUvarint(ktime.CycleNumber())
+	r.writeUvarint(uint64(ktime.cycleOffset()))
+	r.writeBytes([]byte(protocol))
+	r.writeBytes([]byte(netMsg.Hash))
+	r.writeBytes([]byte(netMsg.From))
+	r.writeBytes(netMsg.Data)
+	r.writeBytes(netMsg.Links)
+}
+
+func (r *MessageRecorder) writeUvarint(v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, v)
+	if _, err := r.w.Write(buf[:n]); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *MessageRecorder) writeBytes(b []byte) {
+	r.writeUvarint(uint64(len(b)))
+	if _, err := r.w.Write(b); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// Flush writes buffered records to the file. The kernel flushes at the
+// end of every cycle.
+func (r *MessageRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return errors.New("message recorder is closed")
+	}
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+func (r *MessageRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.w.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file = nil
+	return err
+}
+
+// ReadMessageLog reads the log file at path, returning the peer ID of
+// the recording node and the messages in the order they were received.
+func ReadMessageLog(path string) (string, []*RecordedMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	rd := bufio.NewReader(f)
+
+	magic := make([]byte, len(messageLogMagic))
+	if _, err := io.ReadFull(rd, magic); err != nil || string(magic) != messageLogMagic {
+		return "", nil, fmt.Errorf("%s is not a message log", path)
+	}
+	peerID, err := readLogBytes(rd)
+	if err != nil {
+		return "", nil, err
+	}
+
+	msgs := make([]*RecordedMessage, 0)
+	for {
+		cycle, err := binary.ReadUvarint(rd)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		m := &RecordedMessage{Cycle: cycle}
+		offset, err := binary.ReadUvarint(rd)
+		if err != nil {
+			return "", nil, fmt.Errorf("truncated record %d: %v", len(msgs), err)
+		}
+		m.Offset = time.Duration(offset)
+
+		fields := make([][]byte, 5)
+		for i := range fields {
+			if fields[i], err = readLogBytes(rd); err != nil {
+				return "", nil, fmt.Errorf("truncated record %d: %v", len(msgs), err)
+			}
+		}
+		m.Protocol = string(fields[0])
+		m.Hash = string(fields[1])
+		m.From = string(fields[2])
+		m.Data = fields[3]
+		m.Links = fields[4]
+		msgs = append(msgs, m)
+	}
+	return string(peerID), msgs, nil
+}
+
+func readLogBytes(rd *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(MaxBroadcastSize) {
+		return nil, fmt.Errorf("field of %d bytes exceeds the maximum message size", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rd, b); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return b, nil
+}
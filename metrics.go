@@ -18,79 +18,145 @@ package kernel
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/blocktop/movavg"
 	"github.com/fatih/color"
 	"github.com/golang/glog"
 )
 
 type KernelMetrics struct {
-	cycleTime                   movavg.MultiMA
+	cycleTime                   movingAverage
 	lastCycleTime               float64
-	maintTime                   movavg.MultiMA
+	maintTime                   movingAverage
 	lastMaintTime               float64
-	maintTimePercent            movavg.MultiMA
+	maintTimePercent            movingAverage
 	lastMaintTimePercent        float64
-	genBlockTime                movavg.MultiMA
+	genBlockTime                movingAverage
 	lastGenBlockTime            float64
-	addBlockTime                movavg.MultiMA
+	addBlockTime                movingAverage
 	lastAddBlockTime            float64
-	confBlockTime               movavg.MultiMA
+	confBlockTime               movingAverage
 	lastConfBlockTime           float64
-	evalTime                    movavg.MultiMA
+	evalTime                    movingAverage
 	lastEvalTime                float64
-	computedProcTime            movavg.MultiMA
+	computedProcTime            movingAverage
 	lastComputedProcTime        float64
-	computedProcTimePercent     movavg.MultiMA
+	computedProcTimePercent     movingAverage
 	lastComputedProcTimePercent float64
-	actualProcTime              movavg.MultiMA
+	actualProcTime              movingAverage
 	lastActualProcTime          float64
-	actualProcTimePercent       movavg.MultiMA
+	actualProcTimePercent       movingAverage
 	lastActualProcTimePercent   float64
-	blockQCount                 movavg.MultiMA
+	blockQCount                 movingAverage
 	lastBlockQCount             float64
 	recvQCounts                 *sync.Map
 	lastRecvQCounts             *sync.Map
+	compression                 *sync.Map
+	windows                     []int
+	averaging                   Averaging
+	disabled                    map[string]bool
+	recorder                    *MetricsRecorder
+	traffic                     *traffic
+	stageLatencies              map[string]movingAverage
+	lastStageLatencies          *sync.Map
+	stageHists                  map[string]*histogram
+	timingHists                 map[string]*histogram
+	timingQuantiles             map[string]*windowedQuantiles
+	procOverruns                uint64
+	generatedBlocks             uint64
+	addedBlocks                 uint64
+	addBlocksErrors             uint64
+	lastAddBlocksErrorCycle     uint64
+}
+
+// CompressionStats totals the message bytes of a protocol before and
+// after compression, in each direction.
+type CompressionStats struct {
+	OutboundRawBytes        uint64 `json:"outboundRawBytes,string"`
+	OutboundCompressedBytes uint64 `json:"outboundCompressedBytes,string"`
+	InboundCompressedBytes  uint64 `json:"inboundCompressedBytes,string"`
+	InboundRawBytes         uint64 `json:"inboundRawBytes,string"`
 }
 
 var metrics *KernelMetrics
 var SMAWindows = []int{10, 100, 1000, 10000, 100000, 1000000}
 
-func initMetrics() {
+func initMetrics(c *KernelConfig) {
 	m := &KernelMetrics{}
-	m.cycleTime = movavg.NewMultiSMA(SMAWindows)
-	m.maintTime = movavg.NewMultiSMA(SMAWindows)
-	m.maintTimePercent = movavg.NewMultiSMA(SMAWindows)
-	m.genBlockTime = movavg.NewMultiSMA(SMAWindows)
-	m.addBlockTime = movavg.NewMultiSMA(SMAWindows)
-	m.confBlockTime = movavg.NewMultiSMA(SMAWindows)
-	m.evalTime = movavg.NewMultiSMA(SMAWindows)
-	m.computedProcTime = movavg.NewMultiSMA(SMAWindows)
-	m.computedProcTimePercent = movavg.NewMultiSMA(SMAWindows)
-	m.actualProcTimePercent = movavg.NewMultiSMA(SMAWindows)
-	m.actualProcTime = movavg.NewMultiSMA(SMAWindows)
-	m.blockQCount = movavg.NewMultiSMA(SMAWindows)
-	m.recvQCounts = &sync.Map{}     // [protocol]movavg.MultiMA
+	m.windows = c.MetricsWindows
+	m.averaging = c.MetricsAveraging
+	m.recorder = c.MetricsRecorder
+	m.disabled = make(map[string]bool)
+	for _, series := range c.DisabledMetrics {
+		m.disabled[series] = true
+	}
+
+	m.cycleTime = m.newSeries(SeriesCycle)
+	m.maintTime = m.newSeries(SeriesMaint)
+	m.maintTimePercent = m.newSeries(SeriesMaintPercent)
+	m.genBlockTime = m.newSeries(SeriesGenBlock)
+	m.addBlockTime = m.newSeries(SeriesAddBlock)
+	m.confBlockTime = m.newSeries(SeriesConfBlock)
+	m.evalTime = m.newSeries(SeriesEval)
+	m.computedProcTime = m.newSeries(SeriesScheduledProc)
+	m.computedProcTimePercent = m.newSeries(SeriesScheduledProcPercent)
+	m.actualProcTimePercent = m.newSeries(SeriesProcPercent)
+	m.actualProcTime = m.newSeries(SeriesProc)
+	m.blockQCount = m.newSeries(SeriesBlockQueue)
+	m.recvQCounts = &sync.Map{}     // [protocol]movingAverage
 	m.lastRecvQCounts = &sync.Map{} // [protocol]float64
+	m.compression = &sync.Map{}     // [protocol]*CompressionStats
+	m.traffic = newTraffic()
+	m.traffic.setLimits(c.MaxTrafficPeers, c.TrafficPeerCycles, c.TrafficTopN)
+	m.stageLatencies = make(map[string]movingAverage)
+	m.lastStageLatencies = &sync.Map{} // [interval]float64
+	m.stageHists = make(map[string]*histogram)
+	for _, name := range pipelineIntervalNames {
+		m.stageLatencies[name] = m.newSeries(SeriesPipeline)
+		m.stageHists[name] = newHistogram(timingBuckets)
+	}
+	m.timingHists = make(map[string]*histogram)
+	m.timingQuantiles = make(map[string]*windowedQuantiles)
+	for _, name := range timingNames {
+		m.timingHists[name] = newHistogram(timingBuckets)
+		if !m.disabled[name] {
+			m.timingQuantiles[name] = newWindowedQuantiles(m.windows)
+		}
+	}
 
 	metrics = m
 }
 
+func (m *KernelMetrics) newSeries(series string) movingAverage {
+	if m.disabled[series] {
+		return &disabledAverage{len(m.windows)}
+	}
+	return newMovingAverage(m.averaging, m.windows)
+}
+
+func (m *KernelMetrics) Windows() []int {
+	return m.windows
+}
+
 func (m *KernelMetrics) setCycleTime(duration int64) {
 	fdur := float64(duration)
 	m.cycleTime.Add(fdur)
 
 	maintPercent := 100 * m.lastMaintTime / fdur
 	m.maintTimePercent.Add(maintPercent)
+	m.lastMaintTimePercent = maintPercent
 
 	procPercent := 100 * m.lastActualProcTime / fdur
 	m.actualProcTimePercent.Add(procPercent)
+	m.lastActualProcTimePercent = procPercent
 
-	m.lastActualProcTime = float64(duration)
+	m.lastCycleTime = fdur
+	m.observe(timingCycle, fdur)
 }
 func (m *KernelMetrics) CycleTimes() []float64 {
 	return m.cycleTime.Avg()
@@ -103,6 +169,7 @@ func (m *KernelMetrics) setMaintTime(duration int64) {
 	fdur := float64(duration)
 	m.maintTime.Add(fdur)
 	m.lastMaintTime = fdur
+	m.observe(timingMaint, fdur)
 }
 func (m *KernelMetrics) MaintTimes() []float64 {
 	return m.maintTime.Avg()
@@ -121,6 +188,7 @@ func (m *KernelMetrics) setGenBlockTime(duration int64) {
 	fdur := float64(duration)
 	m.genBlockTime.Add(fdur)
 	m.lastGenBlockTime = fdur
+	m.observe(timingGenBlock, fdur)
 }
 func (m *KernelMetrics) GenBlockTimes() []float64 {
 	return m.genBlockTime.Avg()
@@ -133,6 +201,7 @@ func (m *KernelMetrics) setAddBlockTime(duration int64) {
 	fdur := float64(duration)
 	m.addBlockTime.Add(fdur)
 	m.lastAddBlockTime = fdur
+	m.observe(timingAddBlock, fdur)
 }
 func (m *KernelMetrics) AddBlockTimes() []float64 {
 	return m.addBlockTime.Avg()
@@ -145,6 +214,7 @@ func (m *KernelMetrics) setConfBlockTime(duration int64) {
 	fdur := float64(duration)
 	m.confBlockTime.Add(fdur)
 	m.lastConfBlockTime = fdur
+	m.observe(timingConfBlock, fdur)
 }
 func (m *KernelMetrics) ConfBlockTimes() []float64 {
 	return m.confBlockTime.Avg()
@@ -157,6 +227,7 @@ func (m *KernelMetrics) setEvalTime(duration int64) {
 	fdur := float64(duration)
 	m.evalTime.Add(fdur)
 	m.lastEvalTime = fdur
+	m.observe(timingEval, fdur)
 }
 func (m *KernelMetrics) EvalTimes() []float64 {
 	return m.evalTime.Avg()
@@ -190,6 +261,7 @@ func (m *KernelMetrics) setActualProcTime(duration int64) {
 	fdur := float64(duration)
 	m.actualProcTime.Add(fdur)
 	m.lastActualProcTime = fdur
+	m.observe(timingProc, fdur)
 }
 func (m *KernelMetrics) ActualProcTimes() []float64 {
 	return m.actualProcTime.Avg()
@@ -234,7 +306,7 @@ func (m *KernelMetrics) RecvQCount(name string) float64 {
 func (m *KernelMetrics) RecvQCountsMap() map[string][]float64 {
 	res := make(map[string][]float64)
 	m.recvQCounts.Range(func(n, s interface{}) bool {
-		res[n.(string)] = s.(movavg.MultiMA).Avg()
+		res[n.(string)] = s.(movingAverage).Avg()
 		return true
 	})
 	return res
@@ -248,9 +320,173 @@ func (m *KernelMetrics) RecvQCountMap() map[string]float64 {
 	return res
 }
 
-func (m *KernelMetrics) getRecvQ(name string) movavg.MultiMA {
-	set, _ := m.recvQCounts.LoadOrStore(name, movavg.NewMultiSMA(SMAWindows))
-	return set.(movavg.MultiMA)
+func (m *KernelMetrics) getRecvQ(name string) movingAverage {
+	set, ok := m.recvQCounts.Load(name)
+	if !ok {
+		set, _ = m.recvQCounts.LoadOrStore(name, m.newSeries(SeriesReceiveQueue))
+	}
+	return set.(movingAverage)
+}
+
+func (m *KernelMetrics) addOutboundBytes(protocol string, raw int, compressed int) {
+	stats := m.getCompression(protocol)
+	atomic.AddUint64(&stats.OutboundRawBytes, uint64(raw))
+	atomic.AddUint64(&stats.OutboundCompressedBytes, uint64(compressed))
+}
+func (m *KernelMetrics) addInboundBytes(protocol string, compressed int, raw int) {
+	stats := m.getCompression(protocol)
+	atomic.AddUint64(&stats.InboundCompressedBytes, uint64(compressed))
+	atomic.AddUint64(&stats.InboundRawBytes, uint64(raw))
+}
+func (m *KernelMetrics) CompressionStatsMap() map[string]CompressionStats {
+	res := make(map[string]CompressionStats)
+	m.compression.Range(func(p, s interface{}) bool {
+		stats := s.(*CompressionStats)
+		res[p.(string)] = CompressionStats{
+			OutboundRawBytes:        atomic.LoadUint64(&stats.OutboundRawBytes),
+			OutboundCompressedBytes: atomic.LoadUint64(&stats.OutboundCompressedBytes),
+			InboundCompressedBytes:  atomic.LoadUint64(&stats.InboundCompressedBytes),
+			InboundRawBytes:         atomic.LoadUint64(&stats.InboundRawBytes)}
+		return true
+	})
+	return res
+}
+
+func (m *KernelMetrics) getCompression(protocol string) *CompressionStats {
+	stats, _ := m.compression.LoadOrStore(protocol, &CompressionStats{})
+	return stats.(*CompressionStats)
+}
+
+func (m *KernelMetrics) observe(timing string, duration float64) {
+	m.timingHists[timing].observe(duration / float64(time.Second))
+	if q, ok := m.timingQuantiles[timing]; ok {
+		q.add(duration)
+	}
+}
+
+// Quantiles returns the p50, p90, p99 and maximum of a timing, in
+// nanoseconds, for each moving average window.
+func (m *KernelMetrics) Quantiles(timing string) []TimingQuantiles {
+	q, ok := m.timingQuantiles[timing]
+	if !ok {
+		return nil
+	}
+	return q.quantiles()
+}
+
+// QuantilesMap snapshots the quantiles of every timing.
+func (m *KernelMetrics) QuantilesMap() map[string][]TimingQuantiles {
+	res := make(map[string][]TimingQuantiles)
+	for name, q := range m.timingQuantiles {
+		res[name] = q.quantiles()
+	}
+	return res
+}
+
+// ResetQuantiles discards the samples behind every timing's quantiles.
+// Moving averages and histograms are unaffected.
+func (m *KernelMetrics) ResetQuantiles() {
+	for _, q := range m.timingQuantiles {
+		q.clear()
+	}
+}
+
+func (m *KernelMetrics) incGeneratedBlocks() {
+	atomic.AddUint64(&m.generatedBlocks, 1)
+}
+func (m *KernelMetrics) GeneratedBlocks() uint64 {
+	return atomic.LoadUint64(&m.generatedBlocks)
+}
+
+func (m *KernelMetrics) incAddedBlocks() {
+	atomic.AddUint64(&m.addedBlocks, 1)
+}
+func (m *KernelMetrics) AddedBlocks() uint64 {
+	return atomic.LoadUint64(&m.addedBlocks)
+}
+
+func (m *KernelMetrics) addAddBlocksError() {
+	atomic.AddUint64(&m.addBlocksErrors, 1)
+	atomic.StoreUint64(&m.lastAddBlocksErrorCycle, ktime.CycleNumber())
+}
+func (m *KernelMetrics) AddBlocksErrors() uint64 {
+	return atomic.LoadUint64(&m.addBlocksErrors)
+}
+
+// LastAddBlocksErrorCycle returns the cycle in which AddBlocks last
+// returned an error, or zero if it never has.
+func (m *KernelMetrics) LastAddBlocksErrorCycle() uint64 {
+	return atomic.LoadUint64(&m.lastAddBlocksErrorCycle)
+}
+
+func (m *KernelMetrics) ProcOverruns() uint64 {
+	return atomic.LoadUint64(&m.procOverruns)
+}
+
+func (m *KernelMetrics) setStageLatency(interval string, duration int64) {
+	fdur := float64(duration)
+	m.stageLatencies[interval].Add(fdur)
+	m.lastStageLatencies.Store(interval, fdur)
+	m.stageHists[interval].observe(fdur / float64(time.Second))
+}
+
+// StageLatencies returns the moving averages, in nanoseconds, of the
+// time blocks received from peers spend in each stage of the pipeline.
+func (m *KernelMetrics) StageLatencies() map[string][]float64 {
+	res := make(map[string][]float64)
+	for name, a := range m.stageLatencies {
+		res[name] = a.Avg()
+	}
+	return res
+}
+func (m *KernelMetrics) StageLatency(interval string) float64 {
+	d, ok := m.lastStageLatencies.Load(interval)
+	if !ok {
+		return 0
+	}
+	return d.(float64)
+}
+
+// record appends the metrics of the cycle just completed to the
+// metrics recorder, if one is configured.
+func (m *KernelMetrics) record() {
+	if m.recorder == nil {
+		return
+	}
+	var recvQCount float64
+	for _, c := range m.RecvQCountMap() {
+		recvQCount += c
+	}
+	row := &MetricsRow{
+		Cycle:             ktime.CycleNumber(),
+		BlockNumber:       blk.BlockNumber(),
+		Time:              time.Now().UnixNano(),
+		CycleTime:         m.CycleTime(),
+		MaintTime:         m.MaintTime(),
+		ScheduledProcTime: m.ComputedProcTime(),
+		ProcTime:          m.ActualProcTime(),
+		GenBlockTime:      m.GenBlockTime(),
+		AddBlockTime:      m.AddBlockTime(),
+		ConfBlockTime:     m.ConfBlockTime(),
+		EvalTime:          m.EvalTime(),
+		BlockQueueCount:   m.BlockQCount(),
+		ReceiveQueueCount: recvQCount}
+	if err := m.recorder.Record(row); err != nil {
+		glog.Errorln("Failed to record metrics:", err)
+	}
+}
+
+// History returns the recorded metrics of cycles from through to,
+// inclusive, that remain in the metrics recorder. Run selects the run
+// of the recorder; when zero, the current run is used.
+func (m *KernelMetrics) History(run uint64, from uint64, to uint64) ([]*MetricsRow, error) {
+	if m.recorder == nil {
+		return nil, errors.New("no metrics recorder is configured")
+	}
+	if run == 0 {
+		return m.recorder.Query(from, to)
+	}
+	return m.recorder.QueryRun(run, from, to)
 }
 
 func (m *KernelMetrics) computeProcTime() time.Duration {
@@ -259,6 +495,8 @@ func (m *KernelMetrics) computeProcTime() time.Duration {
 	m.setComputedProcTime(procTime)
 
 	if procTime < 0 {
+		atomic.AddUint64(&m.procOverruns, 1)
+		publish(EventProcOverrun, &ProcOverrunEvent{Overrun: time.Duration(int64(-procTime))})
 		glog.Errorln(color.HiRedString("%s: proc time overrun by %fns", ktime.String(), procTime*-1))
 		return 0
 	}
@@ -269,13 +507,32 @@ func (m *KernelMetrics) String() string {
 	b := strings.Builder{}
 	b.WriteString(fmt.Sprintf("Kernel time (cycle.nanos): %s\n", ktime.String()))
 	b.WriteString(fmt.Sprintf("Kernel uptime (duration): %s\n", ktime.UpTime().String()))
-	b.WriteString(fmt.Sprintf("Moving average windows (num blocks): %v\n", SMAWindows))
+	b.WriteString(fmt.Sprintf("Moving average windows (num blocks): %v\n", m.windows))
 	b.WriteString(fmt.Sprintf("Block queue count: %v\n", m.BlockQCount()))
 	b.WriteString("Receive queue count:\n")
 	rqcs := m.RecvQCountsMap()
 	for n, rqc := range rqcs {
 		b.WriteString(fmt.Sprintf("  %s: %v\n", n, rqc))
 	}
+	b.WriteString("Message bytes (raw/compressed):\n")
+	for p, cs := range m.CompressionStatsMap() {
+		b.WriteString(fmt.Sprintf("  %s: out %d/%d, in %d/%d\n", p,
+			cs.OutboundRawBytes, cs.OutboundCompressedBytes, cs.InboundRawBytes, cs.InboundCompressedBytes))
+	}
+	b.WriteString("Traffic by protocol (messages in/out, dropped, unknown, hash mismatch, bad signature):\n")
+	for p, t := range m.ProtocolTrafficMap() {
+		b.WriteString(fmt.Sprintf("  %s: %s\n", p, t.summary()))
+	}
+	topN := m.traffic.topPeerCount()
+	b.WriteString(fmt.Sprintf("Busiest peers (top %d by bytes in):\n", topN))
+	for _, pt := range m.TopPeers(topN) {
+		b.WriteString(fmt.Sprintf("  %s: %s\n", pt.Peer, pt.summary()))
+	}
+	b.WriteString(fmt.Sprintf("Quietest peers (bottom %d by bytes in):\n", topN))
+	for _, pt := range m.QuietestPeers(topN) {
+		b.WriteString(fmt.Sprintf("  %s: %s\n", pt.Peer, pt.summary()))
+	}
+	b.WriteString(fmt.Sprintf("Other peers: %s\n", m.PeerTraffic(TrafficOther).summary()))
 	b.WriteString("--- Cycles ---\n")
 	b.WriteString(fmt.Sprintf("Cycle number: %d\n", ktime.CycleNumber()))
 	b.WriteString(fmt.Sprintf("Block number: %d\n", blk.BlockNumber()))
@@ -295,54 +552,90 @@ func (m *KernelMetrics) String() string {
 	b.WriteString(fmt.Sprintf("Block confirmation time (ns): %v\n", m.ConfBlockTimes()))
 	b.WriteString(fmt.Sprintf("Head block evaluation time (ns): %v\n", m.ConfBlockTimes()))
 
+	b.WriteString("--- Block Pipeline ---\n")
+	latencies := m.StageLatencies()
+	for _, name := range pipelineIntervalNames {
+		b.WriteString(fmt.Sprintf("Stage %s latency (ns): %v\n", name, latencies[name]))
+	}
+
+	b.WriteString("--- Timing Quantiles (ns) ---\n")
+	for _, name := range timingNames {
+		b.WriteString(fmt.Sprintf("%s:\n", name))
+		for _, q := range m.Quantiles(name) {
+			b.WriteString(fmt.Sprintf("  window %d: p50 %.0f, p90 %.0f, p99 %.0f, max %.0f\n",
+				q.Window, q.P50, q.P90, q.P99, q.Max))
+		}
+	}
+
 	return b.String()
 }
 
 type KernelMetricsJSON struct {
-	KernelTime                        string               `json:"kernelTime"`
-	Uptime                            time.Duration        `json:"uptime"`
-	MovingAverageWindows              []int                `json:"movingAverageWindows"`
-	BlockQueueCount                   float64              `json:"blockQueueCount"`
-	BlockQueueCounts                  []float64            `json:"blockQueueCounts"`
-	ReceiveQueueCount                 map[string]float64   `json:"receiveQueueCount"`
-	ReceiveQueueCounts                map[string][]float64 `json:"receiveQueueCounts"`
-	CycleNumber                       uint64               `json:"cycleNumber,string"`
-	ConfiguredCycleTime               time.Duration        `json:"configuredCycleTime"`
-	ConfiguredBlockFrequency          float64              `json:"configuredBlockFrequency"`
-	ActualCycleTime                   float64              `json:"actualCycleTime"`
-	ActualCycleTimes                  []float64            `json:"actualCycleTimes"`
-	ProcessTimeslice                  float64              `json:"processTimeslice"`
-	ProcessTimeslices                 []float64            `json:"processTimeslices"`
-	ProcessTimeslicePercent           float64              `json:"processTimeslicePercent"`
-	ProcessTimeslicePercents          []float64            `json:"processTimeslicePercents"`
-	ScheduledProcessTimeslice         float64              `json:"scheduleProcessTimeslice"`
-	ScheduledProcessTimeslices        []float64            `json:"scheduledProcessTimeslices"`
-	ScheduledProcessTimeslicePercent  float64              `json:"scheduledProcessTimeslicePercent"`
-	ScheduledProcessTimeslicePercents []float64            `json:"scheduledProcessTimeslicePercents"`
-	BlockGenerationNumber             uint64               `json:"blockGenerationNumber,string"`
-	BlockGenerationTime               float64              `json:"blockGenerationTime"`
-	BlockGenerationTimes              []float64            `json:"blockGenerationTimes"`
-	BlockAddPerformance               float64              `json:"blockAddPerformance"`
-	BlockAddPerformances              []float64            `json:"blockAddPerformances"`
-	MaintenanceTimeslice              float64              `json:"maintenanceTimeslice"`
-	MaintenanceTimeslices             []float64            `json:"maintenanceTimeslices"`
-	MaintenanceTimeslicePercent       float64              `json:"maintenanceTimeslicePercent"`
-	MaintenanceTimeslicePercents      []float64            `json:"maintenanceTimeslicePercents"`
-	BlockConfirmationTime             float64              `json:"blockConfirmationTime"`
-	BlockConfirmationTimes            []float64            `json:"blockConfirmationTimes"`
-	HeadBlockEvaluationTime           float64              `json:"headBlockEvaluationTime"`
-	HeadBlockEvaluationTimes          []float64            `json:"headBlockEvaluationTimes"`
+	KernelTime                        string                       `json:"kernelTime"`
+	Uptime                            time.Duration                `json:"uptime"`
+	MovingAverageWindows              []int                        `json:"movingAverageWindows"`
+	BlockQueueCount                   float64                      `json:"blockQueueCount"`
+	BlockQueueCounts                  []float64                    `json:"blockQueueCounts"`
+	ReceiveQueueCount                 map[string]float64           `json:"receiveQueueCount"`
+	ReceiveQueueCounts                map[string][]float64         `json:"receiveQueueCounts"`
+	Compression                       map[string]CompressionStats  `json:"compression"`
+	ProtocolTraffic                   map[string]TrafficCounts     `json:"protocolTraffic"`
+	TopPeers                          []PeerTraffic                `json:"topPeers"`
+	QuietestPeers                     []PeerTraffic                `json:"quietestPeers"`
+	OtherPeerTraffic                  TrafficCounts                `json:"otherPeerTraffic"`
+	CycleNumber                       uint64                       `json:"cycleNumber,string"`
+	ConfiguredCycleTime               time.Duration                `json:"configuredCycleTime"`
+	ConfiguredBlockFrequency          float64                      `json:"configuredBlockFrequency"`
+	ActualCycleTime                   float64                      `json:"actualCycleTime"`
+	ActualCycleTimes                  []float64                    `json:"actualCycleTimes"`
+	ProcessTimeslice                  float64                      `json:"processTimeslice"`
+	ProcessTimeslices                 []float64                    `json:"processTimeslices"`
+	ProcessTimeslicePercent           float64                      `json:"processTimeslicePercent"`
+	ProcessTimeslicePercents          []float64                    `json:"processTimeslicePercents"`
+	ScheduledProcessTimeslice         float64                      `json:"scheduleProcessTimeslice"`
+	ScheduledProcessTimeslices        []float64                    `json:"scheduledProcessTimeslices"`
+	ScheduledProcessTimeslicePercent  float64                      `json:"scheduledProcessTimeslicePercent"`
+	ScheduledProcessTimeslicePercents []float64                    `json:"scheduledProcessTimeslicePercents"`
+	BlockGenerationNumber             uint64                       `json:"blockGenerationNumber,string"`
+	BlockGenerationTime               float64                      `json:"blockGenerationTime"`
+	BlockGenerationTimes              []float64                    `json:"blockGenerationTimes"`
+	BlockAddPerformance               float64                      `json:"blockAddPerformance"`
+	BlockAddPerformances              []float64                    `json:"blockAddPerformances"`
+	MaintenanceTimeslice              float64                      `json:"maintenanceTimeslice"`
+	MaintenanceTimeslices             []float64                    `json:"maintenanceTimeslices"`
+	MaintenanceTimeslicePercent       float64                      `json:"maintenanceTimeslicePercent"`
+	MaintenanceTimeslicePercents      []float64                    `json:"maintenanceTimeslicePercents"`
+	BlockConfirmationTime             float64                      `json:"blockConfirmationTime"`
+	BlockConfirmationTimes            []float64                    `json:"blockConfirmationTimes"`
+	HeadBlockEvaluationTime           float64                      `json:"headBlockEvaluationTime"`
+	HeadBlockEvaluationTimes          []float64                    `json:"headBlockEvaluationTimes"`
+	TimingQuantiles                   map[string][]TimingQuantiles `json:"timingQuantiles"`
+	PipelineStageLatencies            map[string][]float64         `json:"pipelineStageLatencies"`
 }
 
 func (m *KernelMetrics) JSON() (string, error) {
-	mj := &KernelMetricsJSON{
+	byts, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return "", err
+	}
+	return string(byts), nil
+}
+
+// Snapshot returns the current metrics in the form marshalled by JSON.
+func (m *KernelMetrics) Snapshot() *KernelMetricsJSON {
+	return &KernelMetricsJSON{
 		KernelTime:                        ktime.String(),
 		Uptime:                            ktime.UpTime(),
-		MovingAverageWindows:              SMAWindows,
+		MovingAverageWindows:              m.windows,
 		BlockQueueCounts:                  m.BlockQCounts(),
 		BlockQueueCount:                   m.BlockQCount(),
 		ReceiveQueueCounts:                m.RecvQCountsMap(),
 		ReceiveQueueCount:                 m.RecvQCountMap(),
+		Compression:                       m.CompressionStatsMap(),
+		ProtocolTraffic:                   m.ProtocolTrafficMap(),
+		TopPeers:                          m.TopPeers(m.traffic.topPeerCount()),
+		QuietestPeers:                     m.QuietestPeers(m.traffic.topPeerCount()),
+		OtherPeerTraffic:                  m.PeerTraffic(TrafficOther),
 		CycleNumber:                       ktime.CycleNumber(),
 		ConfiguredCycleTime:               ktime.BlockInterval(),
 		ConfiguredBlockFrequency:          ktime.BlockFrequency(),
@@ -368,11 +661,7 @@ func (m *KernelMetrics) JSON() (string, error) {
 		BlockConfirmationTime:             m.ConfBlockTime(),
 		BlockConfirmationTimes:            m.ConfBlockTimes(),
 		HeadBlockEvaluationTime:           m.EvalTime(),
-		HeadBlockEvaluationTimes:          m.EvalTimes()}
-
-	byts, err := json.Marshal(mj)
-	if err != nil {
-		return "", err
-	}
-	return string(byts), nil
+		HeadBlockEvaluationTimes:          m.EvalTimes(),
+		TimingQuantiles:                   m.QuantilesMap(),
+		PipelineStageLatencies:            m.StageLatencies()}
 }
@@ -17,7 +17,11 @@
 package kernel
 
 import (
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	push "github.com/blocktop/go-push-components"
 	"github.com/blocktop/go-spec"
@@ -25,46 +29,218 @@ import (
 )
 
 type KernelNet struct {
-	node           spec.NetworkNode
-	holdQ          *push.PushBatchQueue
-	holdBroadcasts bool
-	recvQs         *sync.Map
+	node             spec.NetworkNode
+	holdQ            *push.PushBatchQueue
+	holdBroadcasts   bool
+	recvQs           *sync.Map
+	channels         *sync.Map
+	envelopeChannels *sync.Map
+	versionChan      *MessageChannel
+	peerVersions     *peerVersions
+	recorder         *MessageRecorder
+	holdQCapacity    int32 // atomic
+	recvQCapacity    int32 // atomic
+
+	// While the kernel is stepped, inbound messages are held in inbox,
+	// or in delayed until the next cycle, and broadcasts held during
+	// proc in held, so that Step handles them in its own goroutine.
+	stepMu   sync.Mutex
+	stepping bool
+	inbox    []*spec.NetworkMessage
+	delayed  []*spec.NetworkMessage
+	held     []*heldBroadcast
 }
 
 var net *KernelNet
 
-func initNet(node spec.NetworkNode) {
+// MaxBroadcastSize is the largest message, in bytes of data plus links,
+// that Broadcast will accept.
+var MaxBroadcastSize = 16 * 1024 * 1024
+
+func initNet(c *KernelConfig) {
 	n := &KernelNet{}
-	n.node = node
-	n.holdQ = push.NewPushBatchQueue(1, 100000, 1000, n.broadcastHoldDrainWorker)
+	n.node = c.NetworkNode
+	n.recorder = c.MessageRecorder
+	n.setCapacities(c.HoldQueueCapacity, c.ReceiveQueueCapacity)
+	n.holdQ = push.NewPushBatchQueue(1, c.HoldQueueCapacity, c.BroadcastBatchSize, n.broadcastHoldDrainWorker)
 	n.recvQs = &sync.Map{}
+	n.channels = &sync.Map{}         // [protocol]*MessageChannel
+	n.envelopeChannels = &sync.Map{} // [envelope protocol]*MessageChannel
+	n.peerVersions = newPeerVersions()
+	n.peerVersions.setLimits(c.MaxVersionPeers, c.VersionPeerCycles)
+	// Inbound messages are held until the kernel is first stepped or
+	// started.
+	n.stepping = true
 	n.setupMessageReceiver()
 
+	n.versionChan = NewMessageChannel(&versionAnnouncement{}, n.versionHandler)
+	if err := n.RegisterMessageChannel(n.versionChan); err != nil {
+		panic(err)
+	}
+
 	net = n
 }
 
-func (n *KernelNet) RegisterMessageChannel(channel *MessageChannel) {
-	_, ok := n.recvQs.Load(channel.Protocol.String())
-	if ok {
+// RegisterMessageChannel begins routing messages of the channel's
+// protocol to its ReceiveHandler. The channel's Prototype must be a
+// non-nil pointer so that inbound messages can be unmarshalled into new
+// instances of it. Peers learn of the channel's versions at the next
+// maint.
+func (n *KernelNet) RegisterMessageChannel(channel *MessageChannel) error {
+	if err := checkPrototype(channel.Prototype); err != nil {
+		return err
+	}
+	c, loaded := n.channels.LoadOrStore(channel.Protocol.String(), channel)
+	if loaded {
+		if c.(*MessageChannel) != channel {
+			return fmt.Errorf("another channel is registered for protocol %s", channel.Protocol.String())
+		}
+		return nil
+	}
+	q := push.NewPushQueue(1, n.recvQCap(), func(item interface{}) {
+		n.receive(channel, item.(*spec.NetworkMessage))
+	})
+	n.recvQs.Store(channel.Protocol.String(), q)
+	n.envelopeChannels.Store(channel.envelopeProtocol.String(), channel)
+	if Started() || kernel.isStepping() {
+		q.Start()
+	}
+	if channel != n.versionChan {
+		n.requestAnnounce()
+	}
+	return nil
+}
+
+// receive verifies a dequeued message and passes it to the channel's
+// ReceiveHandler.
+func (n *KernelNet) receive(channel *MessageChannel, netMsg *spec.NetworkMessage) {
+	tracer.mark(netMsg, stageDequeued)
+	if err := channel.verify(netMsg); err != nil {
+		metrics.addSignatureRejected(netMsg)
+		tracer.end(netMsg, err)
+		glog.Warningf("%s: rejected %s message: %v", ktime.String(), channel.Protocol.String(), err)
 		return
 	}
-	n.recvQs.Store(channel.Protocol.String(), push.NewPushQueue(1, 100000, func(item interface{}) {
-		netMsg := item.(*spec.NetworkMessage)
-		channel.ReceiveHandler(netMsg)
-	}))
+	channel.ReceiveHandler(netMsg)
+}
+
+// UnregisterMessageChannel stops routing messages of the channel's
+// protocol. Messages waiting in its receive queue are discarded.
+func (n *KernelNet) UnregisterMessageChannel(channel *MessageChannel) error {
+	if channel == n.versionChan {
+		return errors.New("the version handshake channel cannot be unregistered")
+	}
+	protocol := channel.Protocol.String()
+	c, ok := n.channels.Load(protocol)
+	if !ok || c.(*MessageChannel) != channel {
+		return fmt.Errorf("channel is not registered for protocol %s", protocol)
+	}
+	n.channels.Delete(protocol)
+	n.envelopeChannels.Delete(channel.envelopeProtocol.String())
+	q, ok := n.recvQs.Load(protocol)
+	if ok {
+		n.recvQs.Delete(protocol)
+		q.(*push.PushQueue).Stop()
+	}
+	n.requestAnnounce()
+	return nil
 }
 
-func (n *KernelNet) Broadcast(netMsg *spec.NetworkMessage) {
-	// TODO: some immediate message integrity checks, and return an error?
-	if n.holdBroadcasts {
-		n.holdQ.Put(netMsg)
+// Broadcast validates the message and sends it to the network. The
+// message data is the item as marshalled by the channel's Prototype.
+// During the proc timeslice the message is held until the timeslice
+// ends; the returned future reports whether it was eventually sent or
+// dropped.
+func (n *KernelNet) Broadcast(netMsg *spec.NetworkMessage) (*BroadcastFuture, error) {
+	c, err := n.validate(netMsg)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := c.seal(netMsg)
+	if err != nil {
+		return nil, err
+	}
+	return n.broadcast(sealed)
+}
+
+func (n *KernelNet) broadcast(netMsg *spec.NetworkMessage) (*BroadcastFuture, error) {
+	size := len(netMsg.Data) + len(netMsg.Links)
+	if size > MaxBroadcastSize {
+		return nil, fmt.Errorf("broadcast message size %d exceeds limit of %d bytes", size, MaxBroadcastSize)
+	}
+
+	future := newBroadcastFuture()
+	if n.holdBroadcasts && n.isStepping() {
+		n.stepMu.Lock()
+		defer n.stepMu.Unlock()
+		if len(n.held) >= n.holdQCap() {
+			metrics.addDropped(netMsg)
+			err := errors.New("broadcast hold queue is full")
+			future.resolve(err)
+			return future, err
+		}
+		n.held = append(n.held, &heldBroadcast{netMsg: netMsg, future: future})
+	} else if n.holdBroadcasts {
+		if n.holdQ.Count() >= n.holdQCap() {
+			metrics.addDropped(netMsg)
+			err := errors.New("broadcast hold queue is full")
+			future.resolve(err)
+			return future, err
+		}
+		n.holdQ.Put(&heldBroadcast{netMsg: netMsg, future: future})
 	} else {
-		n.priorityBroadcast(netMsg)
+		future.resolve(n.sendBroadcast([]*spec.NetworkMessage{netMsg}))
 	}
+	return future, nil
+}
+
+func (n *KernelNet) validate(netMsg *spec.NetworkMessage) (*MessageChannel, error) {
+	if netMsg == nil {
+		return nil, errors.New("broadcast message is nil")
+	}
+	if netMsg.Protocol == nil {
+		return nil, errors.New("broadcast message has no protocol")
+	}
+	c, ok := n.channels.Load(netMsg.Protocol.String())
+	if !ok {
+		return nil, fmt.Errorf("no message channel registered for protocol %s", netMsg.Protocol.String())
+	}
+	channel := c.(*MessageChannel)
+	size := len(netMsg.Data) + len(netMsg.Links)
+	if size > MaxBroadcastSize {
+		return nil, fmt.Errorf("broadcast message size %d exceeds limit of %d bytes", size, MaxBroadcastSize)
+	}
+	item := channel.newItem()
+	if err := item.Unmarshal(netMsg.Data, netMsg.Links); err != nil {
+		return nil, fmt.Errorf("broadcast message data does not unmarshal: %v", err)
+	}
+	if item.Hash() != netMsg.Hash {
+		return nil, errors.New("broadcast message hash does not match data")
+	}
+	return channel, nil
 }
 
 func (n *KernelNet) priorityBroadcast(netMsg *spec.NetworkMessage) {
-	n.node.Broadcast([]*spec.NetworkMessage{netMsg})
+	if err := n.sendBroadcast([]*spec.NetworkMessage{netMsg}); err != nil {
+		glog.Errorln(err)
+	}
+}
+
+// sendBroadcast hands messages to the network node, converting a
+// panic inside the node into an error.
+func (n *KernelNet) sendBroadcast(netMsgs []*spec.NetworkMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("network node failed to broadcast: %v", r)
+		}
+	}()
+	wire := make([]*spec.NetworkMessage, len(netMsgs))
+	for i, netMsg := range netMsgs {
+		wire[i] = n.toWire(netMsg)
+	}
+	n.node.Broadcast(wire)
+	metrics.addMessagesOut(netMsgs)
+	return nil
 }
 
 func (n *KernelNet) start() {
@@ -73,6 +249,8 @@ func (n *KernelNet) start() {
 		pq.Start()
 		return true
 	})
+
+	n.announceVersions()
 }
 
 func (n *KernelNet) stop() {
@@ -83,6 +261,43 @@ func (n *KernelNet) stop() {
 	})
 }
 
+// protocol returns the protocol or envelope protocol of the given name
+// of a registered channel, or nil.
+func (n *KernelNet) protocol(name string) *spec.MessageProtocol {
+	if c, ok := n.channels.Load(name); ok {
+		return c.(*MessageChannel).Protocol
+	}
+	if c, ok := n.envelopeChannels.Load(name); ok {
+		return c.(*MessageChannel).envelopeProtocol
+	}
+	return nil
+}
+
+func (n *KernelNet) flushRecorder() {
+	if n.recorder == nil {
+		return
+	}
+	if err := n.recorder.Flush(); err != nil {
+		glog.Errorln("failed to flush message recorder:", err)
+	}
+}
+
+// setCapacities sets the limits of the hold and receive queues. Queues
+// already made keep their own capacity, so a lowered limit is enforced
+// by the count checks.
+func (n *KernelNet) setCapacities(hold int, recv int) {
+	atomic.StoreInt32(&n.holdQCapacity, int32(hold))
+	atomic.StoreInt32(&n.recvQCapacity, int32(recv))
+}
+
+func (n *KernelNet) holdQCap() int {
+	return int(atomic.LoadInt32(&n.holdQCapacity))
+}
+
+func (n *KernelNet) recvQCap() int {
+	return int(atomic.LoadInt32(&n.recvQCapacity))
+}
+
 func (n *KernelNet) PeerID() string {
 	return n.node.PeerID()
 }
@@ -106,7 +321,13 @@ func (n *KernelNet) beginProc() {
 }
 
 func (n *KernelNet) endProc() {
-	glog.V(3).Infof("%s: resuming message broadcasts, sending %d held messages", ktime.String(), n.holdQ.Count())
+	if n.isStepping() {
+		n.endStepProc()
+		return
+	}
+	held := n.holdQ.Count()
+	glog.V(3).Infof("%s: resuming message broadcasts, sending %d held messages", ktime.String(), held)
+	reporter.update(func(c *CycleReport) { c.BroadcastsReleased = held })
 	done := make(chan bool)
 	n.holdQ.OnDrained(func() {
 		done <- true
@@ -117,30 +338,82 @@ func (n *KernelNet) endProc() {
 }
 
 func (n *KernelNet) broadcastHoldDrainWorker(items []interface{}) {
-	n.node.Broadcast(castToNetMsg(items))
+	held, err := castToHeldBroadcasts(items)
+	if err != nil {
+		glog.Errorln(err)
+	}
+	if len(held) == 0 {
+		return
+	}
+
+	netMsgs := make([]*spec.NetworkMessage, len(held))
+	for i, h := range held {
+		netMsgs[i] = h.netMsg
+	}
+
+	err = n.sendBroadcast(netMsgs)
+	if err != nil {
+		glog.Errorln(err)
+	}
+	for _, h := range held {
+		h.future.resolve(err)
+	}
 }
 
-func castToNetMsg(items []interface{}) []*spec.NetworkMessage {
-	res := make([]*spec.NetworkMessage, 0)
+func castToHeldBroadcasts(items []interface{}) ([]*heldBroadcast, error) {
+	res := make([]*heldBroadcast, 0, len(items))
+	var err error
 	for _, item := range items {
-		netMsg, ok := item.(*spec.NetworkMessage)
+		h, ok := item.(*heldBroadcast)
 		if !ok {
-			glog.Errorln("Broadcast item was not NetworkMessage")
-			return nil
+			err = fmt.Errorf("broadcast item was %T, not a held message", item)
+			continue
 		}
-		res = append(res, netMsg)
+		res = append(res, h)
 	}
-	return res
+	return res, err
 }
 
 func (n *KernelNet) setupMessageReceiver() {
 	n.node.OnMessageReceived(func(netMsg *spec.NetworkMessage) {
-		q, ok := n.recvQs.Load(netMsg.Protocol.String())
-		if !ok {
-			glog.Warningf("Unknown message protocol received %s", netMsg.Protocol.String())
+		if n.recorder != nil {
+			n.recorder.record(netMsg)
+		}
+		netMsg = n.fromWire(netMsg)
+		n.peerVersions.seen(netMsg.From)
+		metrics.addMessageIn(netMsg)
+		if faults.inject(FaultDropMessage, netMsg.Protocol.String()) != nil {
+			metrics.addDropped(netMsg)
 			return
 		}
-		queue := q.(*push.PushQueue)
-		queue.Put(netMsg)
+		if fault := faults.inject(FaultDelayMessage, netMsg.Protocol.String()); fault != nil {
+			if n.delay(netMsg) {
+				return
+			}
+			time.AfterFunc(fault.Delay, func() { n.enqueue(netMsg) })
+			return
+		}
+		n.enqueue(netMsg)
 	})
 }
+
+func (n *KernelNet) enqueue(netMsg *spec.NetworkMessage) {
+	q, ok := n.recvQs.Load(netMsg.Protocol.String())
+	if !ok {
+		metrics.addUnknownProtocol(netMsg)
+		glog.Warningf("Unknown message protocol received %s", netMsg.Protocol.String())
+		return
+	}
+	if n.hold(netMsg) {
+		return
+	}
+	queue := q.(*push.PushQueue)
+	tracer.begin(netMsg)
+	if queue.Count() >= n.recvQCap() {
+		metrics.addDropped(netMsg)
+		tracer.end(netMsg, errors.New("receive queue full"))
+		glog.Warningf("%s: receive queue full, dropped %s message from %s", ktime.String(), netMsg.Protocol.String(), netMsg.From)
+		return
+	}
+	queue.Put(netMsg)
+}
@@ -0,0 +1,100 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// BlockchainPlugin is the blockchain implementation a node runs.
+type BlockchainPlugin struct {
+	Blockchain     spec.Blockchain
+	Consensus      spec.Consensus
+	BlockPrototype spec.Marshalled
+}
+
+// BlockchainFactory makes a BlockchainPlugin from the blockchain section
+// of the node's configuration. Nested sections are maps, and keys are
+// lower case.
+type BlockchainFactory func(settings map[string]interface{}) (*BlockchainPlugin, error)
+
+// NetworkFactory makes the network node from the network section of the
+// node's configuration, given as to a BlockchainFactory.
+type NetworkFactory func(settings map[string]interface{}) (spec.NetworkNode, error)
+
+var registry = struct {
+	sync.Mutex
+	blockchains map[string]BlockchainFactory
+	networks    map[string]NetworkFactory
+}{
+	blockchains: make(map[string]BlockchainFactory),
+	networks:    make(map[string]NetworkFactory)}
+
+// RegisterBlockchain makes a blockchain implementation available by
+// name to programs, such as kerneld, that choose it from configuration.
+// It is meant to be called from an init function, and panics if the
+// name is already registered.
+func RegisterBlockchain(name string, factory BlockchainFactory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.blockchains[name]; ok {
+		panic(fmt.Sprintf("blockchain %s is already registered", name))
+	}
+	registry.blockchains[name] = factory
+}
+
+// RegisterNetwork makes a network node implementation available by
+// name, as RegisterBlockchain does for blockchains.
+func RegisterNetwork(name string, factory NetworkFactory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.networks[name]; ok {
+		panic(fmt.Sprintf("network %s is already registered", name))
+	}
+	registry.networks[name] = factory
+}
+
+func LookupBlockchain(name string) (BlockchainFactory, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	f, ok := registry.blockchains[name]
+	return f, ok
+}
+
+func LookupNetwork(name string) (NetworkFactory, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	f, ok := registry.networks[name]
+	return f, ok
+}
+
+// RegisteredBlockchains returns the names of the registered blockchains
+// in order.
+func RegisteredBlockchains() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	names := make([]string, 0, len(registry.blockchains))
+	for name := range registry.blockchains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -18,6 +18,7 @@ package kernel
 
 import (
 	"context"
+	"sort"
 	"sync"
 )
 
@@ -71,6 +72,29 @@ func (p *KernelProc) Kill(pid uint) {
 	}
 }
 
+// ProcessInfo describes a scheduled process.
+type ProcessInfo struct {
+	PID       uint   `json:"pid"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Running   bool   `json:"running"`
+}
+
+func (p *KernelProc) List() []ProcessInfo {
+	res := make([]ProcessInfo, 0)
+	p.procs.Range(func(id, pr interface{}) bool {
+		kp := pr.(*kproc)
+		res = append(res, ProcessInfo{
+			PID:       kp.pid,
+			Name:      kp.process.Name(),
+			Namespace: kp.process.Namespace(),
+			Running:   kp.running})
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].PID < res[j].PID })
+	return res
+}
+
 func (p *KernelProc) run(ctx context.Context) {
 	p.procs.Range(func(id, pr interface{}) bool {
 		kp := pr.(*kproc)
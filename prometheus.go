@@ -0,0 +1,170 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const promNamespace = "blocktop_kernel"
+
+// PrometheusHandler serves the kernel metrics in the Prometheus text
+// exposition format. Timings are exported as histograms in seconds and
+// queue depths as gauges of their latest values.
+func PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Initialized() {
+			http.Error(w, "kernel not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(metrics.prometheus())
+	})
+}
+
+func (m *KernelMetrics) prometheus() []byte {
+	p := &promWriter{}
+
+	p.metric("cycles_total", "counter", "Block cycles started.")
+	p.sample("cycles_total", nil, float64(ktime.CycleNumber()))
+	p.metric("uptime_seconds", "gauge", "Time since the kernel started.")
+	p.sample("uptime_seconds", nil, ktime.UpTime().Seconds())
+	p.metric("block_frequency", "gauge", "Configured blocks per second.")
+	p.sample("block_frequency", nil, ktime.BlockFrequency())
+	p.metric("block_number", "gauge", "Number of the block being generated.")
+	p.sample("block_number", nil, float64(blk.BlockNumber()))
+	p.metric("generated_blocks_total", "counter", "Blocks generated locally.")
+	p.sample("generated_blocks_total", nil, float64(m.GeneratedBlocks()))
+	p.metric("added_blocks_total", "counter", "Blocks added to the blockchain.")
+	p.sample("added_blocks_total", nil, float64(m.AddedBlocks()))
+	p.metric("add_blocks_errors_total", "counter", "Calls to Blockchain.AddBlocks that returned an error.")
+	p.sample("add_blocks_errors_total", nil, float64(m.AddBlocksErrors()))
+	p.metric("proc_overruns_total", "counter", "Cycles in which maintenance left no proc time.")
+	p.sample("proc_overruns_total", nil, float64(m.ProcOverruns()))
+
+	p.metric("scheduled_proc_seconds", "gauge", "Proc timeslice scheduled for the latest cycle.")
+	p.sample("scheduled_proc_seconds", nil, m.ComputedProcTime()/float64(time.Second))
+	p.metric("proc_percent", "gauge", "Proc timeslice as a percentage of the latest cycle.")
+	p.sample("proc_percent", nil, m.ActualProcTimePercent())
+	p.metric("maint_percent", "gauge", "Maintenance timeslice as a percentage of the latest cycle.")
+	p.sample("maint_percent", nil, m.MaintTimePercent())
+
+	p.metric("timing_seconds", "histogram", "Duration of kernel operations.")
+	for _, name := range timingNames {
+		p.histogram("timing_seconds", map[string]string{"timing": name}, m.timingHists[name].snapshot())
+	}
+
+	p.metric("pipeline_stage_seconds", "histogram", "Time blocks from peers spend in each stage of the receive pipeline.")
+	for _, name := range pipelineIntervalNames {
+		p.histogram("pipeline_stage_seconds", map[string]string{"stage": name}, m.stageHists[name].snapshot())
+	}
+
+	p.metric("block_queue_depth", "gauge", "Blocks waiting in the block queues.")
+	p.sample("block_queue_depth", nil, m.BlockQCount())
+	p.metric("receive_queue_depth", "gauge", "Messages waiting in a protocol's receive queue.")
+	rqc := m.RecvQCountMap()
+	protocols := make([]string, 0, len(rqc))
+	for protocol := range rqc {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	for _, protocol := range protocols {
+		p.sample("receive_queue_depth", map[string]string{"protocol": protocol}, rqc[protocol])
+	}
+
+	p.metric("message_bytes_total", "counter", "Message bytes by protocol, direction and stage of compression.")
+	cs := m.CompressionStatsMap()
+	protocols = make([]string, 0, len(cs))
+	for protocol := range cs {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	for _, protocol := range protocols {
+		stats := cs[protocol]
+		p.bytesSample(protocol, "out", "raw", stats.OutboundRawBytes)
+		p.bytesSample(protocol, "out", "compressed", stats.OutboundCompressedBytes)
+		p.bytesSample(protocol, "in", "compressed", stats.InboundCompressedBytes)
+		p.bytesSample(protocol, "in", "raw", stats.InboundRawBytes)
+	}
+
+	return p.Bytes()
+}
+
+type promWriter struct {
+	bytes.Buffer
+}
+
+func (p *promWriter) metric(name string, kind string, help string) {
+	fmt.Fprintf(p, "# HELP %s_%s %s\n", promNamespace, name, help)
+	fmt.Fprintf(p, "# TYPE %s_%s %s\n", promNamespace, name, kind)
+}
+
+func (p *promWriter) sample(name string, labels map[string]string, v float64) {
+	fmt.Fprintf(p, "%s_%s%s %s\n", promNamespace, name, promLabels(labels), promFloat(v))
+}
+
+func (p *promWriter) bytesSample(protocol string, direction string, stage string, v uint64) {
+	labels := map[string]string{"protocol": protocol, "direction": direction, "stage": stage}
+	p.sample("message_bytes_total", labels, float64(v))
+}
+
+func (p *promWriter) histogram(name string, labels map[string]string, h *histogramSnapshot) {
+	bucketLabels := make(map[string]string)
+	for k, v := range labels {
+		bucketLabels[k] = v
+	}
+	for i, bound := range h.bounds {
+		bucketLabels["le"] = promFloat(bound)
+		p.sample(name+"_bucket", bucketLabels, float64(h.counts[i]))
+	}
+	bucketLabels["le"] = "+Inf"
+	p.sample(name+"_bucket", bucketLabels, float64(h.count))
+	p.sample(name+"_sum", labels, h.sum)
+	p.sample(name+"_count", labels, float64(h.count))
+}
+
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", name, promEscape(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var promEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+
+func promEscape(v string) string {
+	return promEscaper.Replace(v)
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,172 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// TimingQuantiles summarizes the distribution of a timing, in
+// nanoseconds, over the most recent samples of a moving window.
+type TimingQuantiles struct {
+	Window int     `json:"window"`
+	Count  uint64  `json:"count,string"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P99    float64 `json:"p99"`
+	Max    float64 `json:"max"`
+}
+
+// quantileAccuracy is the relative error of quantile estimates.
+const quantileAccuracy = 0.01
+
+var quantileGamma = (1 + quantileAccuracy) / (1 - quantileAccuracy)
+var quantileLogGamma = math.Log(quantileGamma)
+
+// quantileSketch estimates quantiles by counting observations in
+// buckets whose bounds grow geometrically, so that any quantile is
+// reported within quantileAccuracy of its true value while memory
+// grows only with the logarithm of the range of values.
+type quantileSketch struct {
+	buckets map[int]uint64
+	zeros   uint64
+	count   uint64
+	max     float64
+}
+
+func newQuantileSketch() *quantileSketch {
+	return &quantileSketch{buckets: make(map[int]uint64)}
+}
+
+func (s *quantileSketch) add(v float64) {
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	if v <= 1 {
+		s.zeros++
+		return
+	}
+	s.buckets[int(math.Ceil(math.Log(v)/quantileLogGamma))]++
+}
+
+func (s *quantileSketch) merge(o *quantileSketch) {
+	if o.count == 0 {
+		return
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.zeros += o.zeros
+	for i, n := range o.buckets {
+		s.buckets[i] += n
+	}
+}
+
+func (s *quantileSketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.count-1))
+	if rank < s.zeros {
+		return 0
+	}
+	seen := s.zeros
+
+	indexes := make([]int, 0, len(s.buckets))
+	for i := range s.buckets {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	for _, i := range indexes {
+		seen += s.buckets[i]
+		if seen > rank {
+			v := 2 * math.Pow(quantileGamma, float64(i)) / (quantileGamma + 1)
+			return math.Min(v, s.max)
+		}
+	}
+	return s.max
+}
+
+// windowedQuantiles keeps a pair of sketches for each moving window.
+// The current sketch is retired once it holds a full window of samples,
+// so estimates cover between one and two windows of the most recent
+// samples.
+type windowedQuantiles struct {
+	mu       sync.Mutex
+	windows  []int
+	current  []*quantileSketch
+	previous []*quantileSketch
+}
+
+func newWindowedQuantiles(windows []int) *windowedQuantiles {
+	w := &windowedQuantiles{windows: windows}
+	w.reset()
+	return w
+}
+
+func (w *windowedQuantiles) reset() {
+	w.current = make([]*quantileSketch, len(w.windows))
+	w.previous = make([]*quantileSketch, len(w.windows))
+	for i := range w.windows {
+		w.current[i] = newQuantileSketch()
+		w.previous[i] = newQuantileSketch()
+	}
+}
+
+func (w *windowedQuantiles) add(v float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, window := range w.windows {
+		w.current[i].add(v)
+		if w.current[i].count >= uint64(window) {
+			w.previous[i] = w.current[i]
+			w.current[i] = newQuantileSketch()
+		}
+	}
+}
+
+func (w *windowedQuantiles) quantiles() []TimingQuantiles {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	res := make([]TimingQuantiles, len(w.windows))
+	for i, window := range w.windows {
+		s := newQuantileSketch()
+		s.merge(w.previous[i])
+		s.merge(w.current[i])
+		res[i] = TimingQuantiles{
+			Window: window,
+			Count:  s.count,
+			P50:    s.quantile(0.5),
+			P90:    s.quantile(0.9),
+			P99:    s.quantile(0.99),
+			Max:    s.max}
+	}
+	return res
+}
+
+func (w *windowedQuantiles) clear() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.reset()
+}
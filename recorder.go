@@ -0,0 +1,262 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MetricsRow holds the metrics of one block cycle. Timings are in
+// nanoseconds. Run counts the times the recorder file has been opened,
+// so that rows of the same cycle number recorded before and after a
+// restart are kept apart.
+//
+// ReceiveQueueCount is the sum of the receive queue counts of all
+// protocols. Rows have a fixed size while the set of protocols changes
+// as channels are registered, so the counts per protocol are reported
+// only by KernelMetrics.RecvQCountMap.
+type MetricsRow struct {
+	Run               uint64  `json:"run,string"`
+	Cycle             uint64  `json:"cycle,string"`
+	BlockNumber       uint64  `json:"blockNumber,string"`
+	Time              int64   `json:"time,string"`
+	CycleTime         float64 `json:"cycleTime"`
+	MaintTime         float64 `json:"maintTime"`
+	ScheduledProcTime float64 `json:"scheduledProcTime"`
+	ProcTime          float64 `json:"procTime"`
+	GenBlockTime      float64 `json:"genBlockTime"`
+	AddBlockTime      float64 `json:"addBlockTime"`
+	ConfBlockTime     float64 `json:"confBlockTime"`
+	EvalTime          float64 `json:"evalTime"`
+	BlockQueueCount   float64 `json:"blockQueueCount"`
+	ReceiveQueueCount float64 `json:"receiveQueueCount"`
+}
+
+// MetricsRecorder appends a MetricsRow for every cycle to a file of
+// fixed size. Once the file holds its capacity of rows, each new row
+// overwrites the oldest. Rows are written in order of run and cycle,
+// so a range of them is found by binary search.
+//
+// The file begins with a header of recorderHeaderLen bytes:
+//
+//	bytes 0-3   recorderMagic
+//	bytes 4-11  capacity in rows
+//	bytes 12-19 number of rows ever written
+//	bytes 20-27 run number
+//
+// followed by capacity rows of recorderRowLen bytes each.
+type MetricsRecorder struct {
+	mu       sync.Mutex
+	file     *os.File
+	capacity uint64
+	written  uint64
+	run      uint64
+}
+
+const (
+	recorderMagic     = "BKR2"
+	recorderHeaderLen = 28
+	recorderRowLen    = 14 * 8
+)
+
+// OpenMetricsRecorder opens the ring file at path, creating it with
+// room for capacity rows if it does not exist, and begins a new run.
+func OpenMetricsRecorder(path string, capacity int) (*MetricsRecorder, error) {
+	if capacity <= 0 {
+		return nil, errors.New("metrics recorder capacity must be positive")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := &MetricsRecorder{file: f, capacity: uint64(capacity)}
+
+	header := make([]byte, recorderHeaderLen)
+	n, _ := f.ReadAt(header, 0)
+	if n > 0 {
+		if n < recorderHeaderLen || string(header[:4]) != recorderMagic {
+			f.Close()
+			return nil, fmt.Errorf("%s is not a metrics recorder file", path)
+		}
+		if c := binary.BigEndian.Uint64(header[4:12]); c != r.capacity {
+			f.Close()
+			return nil, fmt.Errorf("%s has a capacity of %d rows, not %d", path, c, capacity)
+		}
+		r.written = binary.BigEndian.Uint64(header[12:20])
+		r.run = binary.BigEndian.Uint64(header[20:28])
+	}
+	r.run++
+
+	copy(header, recorderMagic)
+	binary.BigEndian.PutUint64(header[4:12], r.capacity)
+	binary.BigEndian.PutUint64(header[12:20], r.written)
+	binary.BigEndian.PutUint64(header[20:28], r.run)
+	if _, err := f.WriteAt(header, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Run returns the run number under which rows are being recorded.
+func (r *MetricsRecorder) Run() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.run
+}
+
+// Record writes the row under the current run.
+func (r *MetricsRecorder) Record(row *MetricsRow) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return errors.New("metrics recorder is closed")
+	}
+	row.Run = r.run
+	offset := int64(recorderHeaderLen + (r.written%r.capacity)*recorderRowLen)
+	if _, err := r.file.WriteAt(encodeMetricsRow(row), offset); err != nil {
+		return err
+	}
+	r.written++
+
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, r.written)
+	_, err := r.file.WriteAt(count, 12)
+	return err
+}
+
+// Query returns the rows of the current run still held for cycles from
+// through to, inclusive, in cycle order.
+func (r *MetricsRecorder) Query(from uint64, to uint64) ([]*MetricsRow, error) {
+	return r.QueryRun(r.Run(), from, to)
+}
+
+// QueryRun returns the rows of the given run still held for cycles from
+// through to, inclusive, in cycle order.
+func (r *MetricsRecorder) QueryRun(run uint64, from uint64, to uint64) ([]*MetricsRow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil, errors.New("metrics recorder is closed")
+	}
+	held := r.written
+	if held > r.capacity {
+		held = r.capacity
+	}
+
+	// search finds the first held row for which after is true. Rows
+	// are in order of run and cycle.
+	var err error
+	search := func(after func(row *MetricsRow) bool) uint64 {
+		return uint64(sort.Search(int(held), func(i int) bool {
+			if err != nil {
+				return true
+			}
+			var row *MetricsRow
+			row, err = r.readRow(uint64(i), held)
+			return err != nil || after(row)
+		}))
+	}
+	first := search(func(row *MetricsRow) bool {
+		return row.Run > run || (row.Run == run && row.Cycle >= from)
+	})
+	last := search(func(row *MetricsRow) bool {
+		return row.Run > run || (row.Run == run && row.Cycle > to)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]*MetricsRow, 0, last-first)
+	for i := first; i < last; i++ {
+		row, err := r.readRow(i, held)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readRow reads the i-th oldest of the held rows.
+func (r *MetricsRecorder) readRow(i uint64, held uint64) (*MetricsRow, error) {
+	slot := i
+	if held == r.capacity {
+		slot = (r.written + i) % r.capacity
+	}
+	data := make([]byte, recorderRowLen)
+	if _, err := r.file.ReadAt(data, int64(recorderHeaderLen+slot*recorderRowLen)); err != nil {
+		return nil, err
+	}
+	return decodeMetricsRow(data), nil
+}
+
+func (r *MetricsRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func encodeMetricsRow(row *MetricsRow) []byte {
+	fields := []uint64{row.Run, row.Cycle, row.BlockNumber, uint64(row.Time),
+		math.Float64bits(row.CycleTime), math.Float64bits(row.MaintTime),
+		math.Float64bits(row.ScheduledProcTime), math.Float64bits(row.ProcTime),
+		math.Float64bits(row.GenBlockTime), math.Float64bits(row.AddBlockTime),
+		math.Float64bits(row.ConfBlockTime), math.Float64bits(row.EvalTime),
+		math.Float64bits(row.BlockQueueCount), math.Float64bits(row.ReceiveQueueCount)}
+
+	data := make([]byte, recorderRowLen)
+	for i, f := range fields {
+		binary.BigEndian.PutUint64(data[i*8:], f)
+	}
+	return data
+}
+
+func decodeMetricsRow(data []byte) *MetricsRow {
+	field := func(i int) uint64 {
+		return binary.BigEndian.Uint64(data[i*8:])
+	}
+	return &MetricsRow{
+		Run:               field(0),
+		Cycle:             field(1),
+		BlockNumber:       field(2),
+		Time:              int64(field(3)),
+		CycleTime:         math.Float64frombits(field(4)),
+		MaintTime:         math.Float64frombits(field(5)),
+		ScheduledProcTime: math.Float64frombits(field(6)),
+		ProcTime:          math.Float64frombits(field(7)),
+		GenBlockTime:      math.Float64frombits(field(8)),
+		AddBlockTime:      math.Float64frombits(field(9)),
+		ConfBlockTime:     math.Float64frombits(field(10)),
+		EvalTime:          math.Float64frombits(field(11)),
+		BlockQueueCount:   math.Float64frombits(field(12)),
+		ReceiveQueueCount: math.Float64frombits(field(13))}
+}
@@ -0,0 +1,205 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+	"github.com/spf13/viper"
+)
+
+// ConfigChange is a change to one KernelConfig field.
+type ConfigChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type ConfigChangedEvent struct {
+	Changes []ConfigChange `json:"changes"`
+}
+
+// Config returns a copy of the configuration in effect, with defaults
+// filled in.
+func Config() *KernelConfig {
+	panicIfUninitialized()
+	kernel.mu.Lock()
+	defer kernel.mu.Unlock()
+	c := *kernel.config
+	c.BlockFrequency = ktime.BlockFrequency()
+	return &c
+}
+
+// UpdateConfig schedules the configuration to take effect at the next
+// maint timeslice and returns the changes it makes. It is rejected, with
+// nothing changed, if it changes any field that can only be set by
+// Init: the blockchain and other components, Genesis, the broadcast
+// batch size and the metrics series, or raises a queue capacity above
+// its value at Init. Block queue settings apply to queues created after
+// the change.
+func UpdateConfig(c *KernelConfig) ([]ConfigChange, error) {
+	panicIfUninitialized()
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	c = c.withDefaults()
+
+	kernel.mu.Lock()
+	defer kernel.mu.Unlock()
+
+	changes, unsafe := diffConfig(kernel.config, c, kernel.initConfig)
+	if len(unsafe) > 0 {
+		return nil, fmt.Errorf("cannot change %s without a restart", strings.Join(unsafe, ", "))
+	}
+	if len(changes) == 0 {
+		return changes, nil
+	}
+	kernel.pendingConfig = c
+	kernel.pendingChanges = changes
+	return changes, nil
+}
+
+// WatchConfig updates the configuration with KernelConfig.FromViper
+// whenever the config file of v changes.
+func WatchConfig(v *viper.Viper) {
+	v.OnConfigChange(func(e fsnotify.Event) {
+		c := Config()
+		if err := c.FromViper(v); err != nil {
+			glog.Errorf("invalid config in %s: %v", e.Name, err)
+			return
+		}
+		changes, err := UpdateConfig(c)
+		if err != nil {
+			glog.Errorf("rejected config change in %s: %v", e.Name, err)
+			return
+		}
+		glog.Infof("config change in %s, %d fields to update", e.Name, len(changes))
+	})
+	v.WatchConfig()
+}
+
+func diffConfig(old *KernelConfig, new *KernelConfig, init *KernelConfig) ([]ConfigChange, []string) {
+	changes := make([]ConfigChange, 0)
+	unsafe := make([]string, 0)
+	add := func(field string, o interface{}, n interface{}, safe bool) {
+		was, now := fmt.Sprint(o), fmt.Sprint(n)
+		if was == now {
+			return
+		}
+		changes = append(changes, ConfigChange{Field: field, Old: was, New: now})
+		if !safe {
+			unsafe = append(unsafe, field)
+		}
+	}
+	ptr := func(v interface{}) string {
+		return fmt.Sprintf("%T %p", v, v)
+	}
+
+	add("Blockchain", old.Blockchain.Name(), new.Blockchain.Name(), false)
+	add("Consensus", ptr(old.Consensus), ptr(new.Consensus), false)
+	add("BlockPrototype", ptr(old.BlockPrototype), ptr(new.BlockPrototype), false)
+	add("NetworkNode", ptr(old.NetworkNode), ptr(new.NetworkNode), false)
+	add("BlockFrequency", ktime.BlockFrequency(), new.BlockFrequency, true)
+	add("Genesis", old.Genesis, new.Genesis, false)
+	add("HoldQueueCapacity", old.HoldQueueCapacity, new.HoldQueueCapacity, new.HoldQueueCapacity <= init.HoldQueueCapacity)
+	add("ReceiveQueueCapacity", old.ReceiveQueueCapacity, new.ReceiveQueueCapacity, new.ReceiveQueueCapacity <= init.ReceiveQueueCapacity)
+	add("BlockQueueCapacity", old.BlockQueueCapacity, new.BlockQueueCapacity, true)
+	add("BlockBatchSize", old.BlockBatchSize, new.BlockBatchSize, true)
+	add("BroadcastBatchSize", old.BroadcastBatchSize, new.BroadcastBatchSize, false)
+	add("ConsecutiveLocalHitsLimit", old.ConsecutiveLocalHitsLimit, new.ConsecutiveLocalHitsLimit, true)
+	add("LogVerbosity", old.LogVerbosity, new.LogVerbosity, true)
+	add("MaxVersionPeers", old.MaxVersionPeers, new.MaxVersionPeers, true)
+	add("VersionPeerCycles", old.VersionPeerCycles, new.VersionPeerCycles, true)
+	add("MaxTrafficPeers", old.MaxTrafficPeers, new.MaxTrafficPeers, true)
+	add("TrafficPeerCycles", old.TrafficPeerCycles, new.TrafficPeerCycles, true)
+	add("TrafficTopN", old.TrafficTopN, new.TrafficTopN, true)
+	add("MetricsWindows", old.MetricsWindows, new.MetricsWindows, false)
+	add("MetricsAveraging", old.MetricsAveraging, new.MetricsAveraging, false)
+	add("DisabledMetrics", old.DisabledMetrics, new.DisabledMetrics, false)
+	add("MetricsRecorder", ptr(old.MetricsRecorder), ptr(new.MetricsRecorder), false)
+	add("MessageRecorder", ptr(old.MessageRecorder), ptr(new.MessageRecorder), false)
+	add("Faults", ptr(old.Faults), ptr(new.Faults), false)
+	add("SpanExporter", ptr(old.SpanExporter), ptr(new.SpanExporter), false)
+	add("StateStore", ptr(old.StateStore), ptr(new.StateStore), false)
+	add("Health", old.Health, new.Health, true)
+	add("RPCAuthenticator", ptr(old.RPCAuthenticator), ptr(new.RPCAuthenticator), false)
+	add("RPCAuditLog", ptr(old.RPCAuditLog), ptr(new.RPCAuditLog), false)
+	return changes, unsafe
+}
+
+// applyConfig puts a pending configuration into effect.
+func (k *Kernel) applyConfig() {
+	k.mu.Lock()
+	c, changes := k.pendingConfig, k.pendingChanges
+	k.pendingConfig, k.pendingChanges = nil, nil
+	k.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	if c.BlockFrequency != ktime.BlockFrequency() {
+		ktime.SetBlockFrequency(c.BlockFrequency)
+	}
+	net.setCapacities(c.HoldQueueCapacity, c.ReceiveQueueCapacity)
+	blk.blockQs.setLimits(c.BlockQueueCapacity, c.BlockBatchSize)
+	blk.localHitsLimit = c.ConsecutiveLocalHitsLimit
+	net.peerVersions.setLimits(c.MaxVersionPeers, c.VersionPeerCycles)
+	metrics.traffic.setLimits(c.MaxTrafficPeers, c.TrafficPeerCycles, c.TrafficTopN)
+	for _, change := range changes {
+		switch change.Field {
+		case "Health":
+			health.setConfig(c.Health, c.Genesis)
+		case "LogVerbosity":
+			setLogVerbosity(c.LogVerbosity)
+		}
+	}
+
+	k.mu.Lock()
+	k.config = c
+	k.mu.Unlock()
+
+	for _, change := range changes {
+		glog.Infof("%s: config %s changed from %s to %s", ktime.String(), change.Field, change.Old, change.New)
+	}
+	publish(EventConfigChanged, &ConfigChangedEvent{Changes: changes})
+}
+
+// flagVerbosity is the glog -v flag as it was before the configuration
+// first set it.
+var flagVerbosity string
+
+// setLogVerbosity sets the glog -v flag. Zero restores the flag as set
+// on the command line.
+func setLogVerbosity(verbosity int) {
+	f := flag.Lookup("v")
+	if f == nil {
+		return
+	}
+	if flagVerbosity == "" {
+		flagVerbosity = f.Value.String()
+	}
+	if verbosity == 0 {
+		f.Value.Set(flagVerbosity)
+		return
+	}
+	f.Value.Set(strconv.Itoa(verbosity))
+}
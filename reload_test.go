@@ -0,0 +1,198 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel_test
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"testing"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/kerneltest"
+)
+
+func initHarness(t *testing.T) *kerneltest.Harness {
+	h := kerneltest.NewHarness("test", "node")
+	if err := h.Config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	h.Init()
+	return h
+}
+
+func step(t *testing.T, h *kerneltest.Harness) {
+	if _, err := h.Step(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateConfigAppliesAtMaint(t *testing.T) {
+	h := initHarness(t)
+	defer kernel.Stop()
+
+	c := kernel.Config()
+	c.BlockFrequency = 50
+	c.BlockBatchSize = 2
+	changes, err := kernel.UpdateConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("changes %+v, want BlockFrequency and BlockBatchSize", changes)
+	}
+	if f := kernel.Time().BlockFrequency(); f != kerneltest.DefaultBlockFrequency {
+		t.Fatalf("block frequency %v before maint, want %v", f, kerneltest.DefaultBlockFrequency)
+	}
+
+	step(t, h)
+	if f := kernel.Time().BlockFrequency(); f != 50 {
+		t.Errorf("block frequency %v after maint, want 50", f)
+	}
+	if n := kernel.Config().BlockBatchSize; n != 2 {
+		t.Errorf("block batch size %d after maint, want 2", n)
+	}
+
+	changes, err = kernel.UpdateConfig(kernel.Config())
+	if err != nil || len(changes) != 0 {
+		t.Errorf("unchanged config gave changes %+v and error %v", changes, err)
+	}
+}
+
+func TestUpdateConfigRejectsRestartFields(t *testing.T) {
+	initHarness(t)
+	defer kernel.Stop()
+
+	c := kernel.Config()
+	c.Genesis = true
+	if _, err := kernel.UpdateConfig(c); err == nil || !strings.Contains(err.Error(), "Genesis") {
+		t.Errorf("changing Genesis gave error %v", err)
+	}
+
+	c = kernel.Config()
+	c.ReceiveQueueCapacity++
+	if _, err := kernel.UpdateConfig(c); err == nil {
+		t.Error("raised ReceiveQueueCapacity above its initial value")
+	}
+
+	c = kernel.Config()
+	c.BlockFrequency = 0
+	if _, err := kernel.UpdateConfig(c); err == nil {
+		t.Error("accepted an invalid config")
+	}
+}
+
+func TestUpdateConfigHealth(t *testing.T) {
+	h := initHarness(t)
+	defer kernel.Stop()
+
+	c := kernel.Config()
+	minPeers := 3
+	c.Health.MinPeers = &minPeers
+	if _, err := kernel.UpdateConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	step(t, h)
+
+	for _, check := range kernel.Health().Checks {
+		if check.Name == "peers" {
+			if !strings.Contains(check.Detail, "3 required") {
+				t.Errorf("peers check %q after update, want 3 required", check.Detail)
+			}
+			return
+		}
+	}
+	t.Error("health report has no peers check")
+}
+
+func TestHealthMinPeers(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		minPeers *int
+		genesis  bool
+		want     string
+	}{
+		{"default", nil, false, "1 required"},
+		{"genesis default", nil, true, "0 required"},
+		{"explicit zero", new(int), false, "0 required"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h := kerneltest.NewHarness("test", "node")
+			h.Config.Genesis = test.genesis
+			h.Config.Health.MinPeers = test.minPeers
+			h.Init()
+			defer kernel.Stop()
+
+			for _, check := range kernel.Health().Checks {
+				if check.Name == "peers" && !strings.Contains(check.Detail, test.want) {
+					t.Errorf("peers check %q, want %s", check.Detail, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateConfigTrafficTopN(t *testing.T) {
+	h := initHarness(t)
+	defer kernel.Stop()
+
+	c := kernel.Config()
+	c.TrafficTopN = 1
+	if _, err := kernel.UpdateConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range []string{"peer1", "peer2", "peer3"} {
+		if err := h.Deliver(kerneltest.NewBlock(1, "parent", []byte(peer)), peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	step(t, h)
+
+	if n := len(kernel.Metrics().Snapshot().TopPeers); n != 1 {
+		t.Errorf("%d top peers after update, want 1", n)
+	}
+}
+
+func TestUpdateConfigLogVerbosity(t *testing.T) {
+	f := flag.Lookup("v")
+	if f == nil {
+		t.Skip("glog -v flag is not registered")
+	}
+	orig := f.Value.String()
+	h := initHarness(t)
+	defer kernel.Stop()
+
+	c := kernel.Config()
+	c.LogVerbosity = 4
+	if _, err := kernel.UpdateConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	step(t, h)
+	if v := f.Value.String(); v != "4" {
+		t.Errorf("verbosity %s after update, want 4", v)
+	}
+
+	c = kernel.Config()
+	c.LogVerbosity = 0
+	if _, err := kernel.UpdateConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	step(t, h)
+	if v := f.Value.String(); v != orig {
+		t.Errorf("verbosity %s after reset, want the flag value %s", v, orig)
+	}
+}
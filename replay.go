@@ -0,0 +1,151 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	spec "github.com/blocktop/go-spec"
+	"github.com/golang/glog"
+)
+
+// ReplayNode is a spec.NetworkNode that feeds the messages of a message
+// log to the kernel at the cycle offsets they were recorded at. Cycles
+// are counted from the first cycle of the log, so a recording taken
+// from a long-running node replays from the first cycle of a fresh
+// kernel. Broadcasts are discarded.
+//
+// The replay begins when Start is called, which must be after the
+// kernel has been initialized with the node.
+type ReplayNode struct {
+	peerID    string
+	msgs      []*RecordedMessage
+	mu        sync.Mutex
+	receiver  spec.MessageReceiver
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	finished  chan struct{}
+}
+
+// NewReplayNode reads the message log at path.
+func NewReplayNode(path string) (*ReplayNode, error) {
+	peerID, msgs, err := ReadMessageLog(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplayNode{
+		peerID:   peerID,
+		msgs:     msgs,
+		done:     make(chan struct{}),
+		finished: make(chan struct{})}, nil
+}
+
+func (n *ReplayNode) PeerID() string {
+	return n.peerID
+}
+
+// Start begins the replay into the kernel initialized with the node.
+// The replay ends early if the context is done or Stop is called.
+func (n *ReplayNode) Start(ctx context.Context) error {
+	n.mu.Lock()
+	receiver := n.receiver
+	n.mu.Unlock()
+	if receiver == nil || ktime == nil || net == nil {
+		return errors.New("replay node is not the network node of an initialized kernel")
+	}
+	n.startOnce.Do(func() {
+		go func() {
+			select {
+			case <-ctx.Done():
+				n.Stop()
+			case <-n.finished:
+			}
+		}()
+		go n.replay(receiver, ktime, net)
+	})
+	return nil
+}
+
+func (n *ReplayNode) Stop() {
+	n.stopOnce.Do(func() { close(n.done) })
+}
+
+func (n *ReplayNode) Broadcast(netMsgs []*spec.NetworkMessage) {
+}
+
+func (n *ReplayNode) OnMessageReceived(receiver spec.MessageReceiver) {
+	n.mu.Lock()
+	n.receiver = receiver
+	n.mu.Unlock()
+}
+
+// Finished is closed once every message of the log has been delivered.
+func (n *ReplayNode) Finished() <-chan struct{} {
+	return n.finished
+}
+
+func (n *ReplayNode) replay(receiver spec.MessageReceiver, t *KernelTime, kn *KernelNet) {
+	defer close(n.finished)
+	if len(n.msgs) == 0 {
+		return
+	}
+	base := n.msgs[0].Cycle
+
+	for _, m := range n.msgs {
+		protocol := n.waitFor(t, kn, m.Cycle-base+1, m.Offset, m.Protocol)
+		select {
+		case <-n.done:
+			return
+		default:
+		}
+		if protocol == nil {
+			glog.Warningf("%s: replay skipped %s message, protocol is not registered", t.String(), m.Protocol)
+			continue
+		}
+		receiver(&spec.NetworkMessage{
+			Data:     m.Data,
+			Links:    m.Links,
+			Hash:     m.Hash,
+			From:     m.From,
+			Protocol: protocol})
+	}
+}
+
+// waitFor blocks until the kernel reaches offset within cycle, then
+// returns the registered protocol of the given name.
+func (n *ReplayNode) waitFor(t *KernelTime, kn *KernelNet, cycle uint64, offset time.Duration, protocol string) *spec.MessageProtocol {
+	for {
+		cur, curOffset := t.cycleAndOffset()
+		if cur > cycle || (cur == cycle && curOffset >= offset) {
+			return kn.protocol(protocol)
+		}
+
+		wait := t.BlockInterval() / 10
+		if cur == cycle && offset-curOffset < wait {
+			wait = offset - curOffset
+		}
+		select {
+		case <-n.done:
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
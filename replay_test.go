@@ -0,0 +1,89 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/kerneltest"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernelreplay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "messages")
+
+	recorder, err := kernel.OpenMessageRecorder(path, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := kerneltest.NewHarness("test", "node")
+	h.Config.MessageRecorder = recorder
+	h.Init()
+	block := kerneltest.NewBlock(1, "parent", []byte("recorded"))
+	if err := h.Deliver(block, "peer1"); err != nil {
+		t.Fatal(err)
+	}
+	step(t, h)
+	kernel.Stop()
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	node, err := kernel.NewReplayNode(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Start(context.Background()); err == nil {
+		t.Error("replay started before the kernel was initialized with the node")
+	}
+	r := kerneltest.NewHarness("test", "node")
+	r.Config.NetworkNode = node
+	r.Init()
+	defer kernel.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := node.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for finished := false; !finished; {
+		select {
+		case <-node.Finished():
+			finished = true
+		case <-deadline:
+			t.Fatal("replay did not finish")
+		case <-time.After(time.Millisecond):
+		}
+		step(t, r)
+	}
+
+	calls := r.Blockchain.AddBlocksCalls()
+	if len(calls) != 1 || calls[0].Blocks[0].Hash() != block.Hash() {
+		t.Fatalf("AddBlocks calls %+v, want the recorded block", calls)
+	}
+}
@@ -18,11 +18,13 @@ package kernel
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"time"
 
 	rpcclient "github.com/blocktop/go-rpc-client/kernel"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -33,8 +35,8 @@ type RPC struct {
 }
 
 func (h *RPC) GetMetrics(r *http.Request, args *rpcclient.GetMetricsArgs, reply *rpcclient.GetMetricsReply) error {
-	if !Initialized() {
-		return errors.New("kernel not initialized")
+	if err := authorize(r, "GetMetrics"); err != nil {
+		return err
 	}
 	switch args.Format {
 	case "text":
@@ -53,3 +55,251 @@ func (h *RPC) GetMetrics(r *http.Request, args *rpcclient.GetMetricsArgs, reply
 
 	return nil
 }
+
+type GetMetricsHistoryArgs struct {
+	Run       uint64 `json:"run,string,omitempty"`
+	FromCycle uint64 `json:"fromCycle,string"`
+	ToCycle   uint64 `json:"toCycle,string"`
+}
+
+type GetMetricsHistoryReply struct {
+	Rows []*MetricsRow `json:"rows"`
+}
+
+func (h *RPC) GetMetricsHistory(r *http.Request, args *GetMetricsHistoryArgs, reply *GetMetricsHistoryReply) error {
+	if err := authorize(r, "GetMetricsHistory"); err != nil {
+		return err
+	}
+	if args.ToCycle < args.FromCycle {
+		return errors.New("toCycle must not be less than fromCycle")
+	}
+	rows, err := metrics.History(args.Run, args.FromCycle, args.ToCycle)
+	if err != nil {
+		return err
+	}
+	reply.Rows = rows
+	return nil
+}
+
+type GetHealthArgs struct {
+}
+
+type GetHealthReply struct {
+	Health *HealthReport `json:"health"`
+}
+
+func (h *RPC) GetHealth(r *http.Request, args *GetHealthArgs, reply *GetHealthReply) error {
+	if err := authorize(r, "GetHealth"); err != nil {
+		return err
+	}
+	reply.Health = health.Report()
+	return nil
+}
+
+type GetStatusArgs struct {
+}
+
+type GetStatusReply struct {
+	Started          bool          `json:"started"`
+	Uptime           time.Duration `json:"uptime"`
+	CycleNumber      uint64        `json:"cycleNumber,string"`
+	RootID           int           `json:"rootID"`
+	BlockNumber      uint64        `json:"blockNumber,string"`
+	BlockFrequency   float64       `json:"blockFrequency"`
+	GenerationPaused bool          `json:"generationPaused"`
+}
+
+func (h *RPC) GetStatus(r *http.Request, args *GetStatusArgs, reply *GetStatusReply) error {
+	if err := authorize(r, "GetStatus"); err != nil {
+		return err
+	}
+	getStatus(reply)
+	return nil
+}
+
+func getStatus(reply *GetStatusReply) {
+	reply.Started = Started()
+	if reply.Started {
+		reply.Uptime = ktime.UpTime()
+	}
+	reply.CycleNumber = ktime.CycleNumber()
+	reply.RootID = blk.RootID()
+	reply.BlockNumber = blk.BlockNumber()
+	reply.BlockFrequency = ktime.BlockFrequency()
+	reply.GenerationPaused = blk.GenerationPaused()
+}
+
+type GetBranchesArgs struct {
+}
+
+type GetBranchesReply struct {
+	Branches []BranchInfo `json:"branches"`
+}
+
+func (h *RPC) GetBranches(r *http.Request, args *GetBranchesArgs, reply *GetBranchesReply) error {
+	if err := authorize(r, "GetBranches"); err != nil {
+		return err
+	}
+	reply.Branches = blk.Branches()
+	return nil
+}
+
+type GetBlockQueuesArgs struct {
+}
+
+type GetBlockQueuesReply struct {
+	Queues []BlockQueueInfo `json:"queues"`
+}
+
+func (h *RPC) GetBlockQueues(r *http.Request, args *GetBlockQueuesArgs, reply *GetBlockQueuesReply) error {
+	if err := authorize(r, "GetBlockQueues"); err != nil {
+		return err
+	}
+	reply.Queues = blk.Queues()
+	return nil
+}
+
+type GetProcessesArgs struct {
+}
+
+type GetProcessesReply struct {
+	Processes []ProcessInfo `json:"processes"`
+}
+
+func (h *RPC) GetProcesses(r *http.Request, args *GetProcessesArgs, reply *GetProcessesReply) error {
+	if err := authorize(r, "GetProcesses"); err != nil {
+		return err
+	}
+	reply.Processes = proc.List()
+	return nil
+}
+
+// ControlReply is the reply to the kernel control methods. It gives the
+// kernel status once the control has been applied.
+type ControlReply struct {
+	Status GetStatusReply `json:"status"`
+}
+
+type StopCycleArgs struct {
+}
+
+func (h *RPC) StopCycle(r *http.Request, args *StopCycleArgs, reply *ControlReply) error {
+	if err := authorize(r, "StopCycle"); err != nil {
+		return err
+	}
+	Stop()
+	getStatus(&reply.Status)
+	return nil
+}
+
+type StartCycleArgs struct {
+}
+
+func (h *RPC) StartCycle(r *http.Request, args *StartCycleArgs, reply *ControlReply) error {
+	if err := authorize(r, "StartCycle"); err != nil {
+		return err
+	}
+	if err := restart(); err != nil {
+		return err
+	}
+	getStatus(&reply.Status)
+	return nil
+}
+
+type PauseGenerationArgs struct {
+	// Resume resumes block generation instead of pausing it.
+	Resume bool `json:"resume"`
+}
+
+func (h *RPC) PauseGeneration(r *http.Request, args *PauseGenerationArgs, reply *ControlReply) error {
+	if err := authorize(r, "PauseGeneration"); err != nil {
+		return err
+	}
+	if args.Resume {
+		blk.ResumeGeneration()
+	} else {
+		blk.PauseGeneration()
+	}
+	getStatus(&reply.Status)
+	return nil
+}
+
+type SetBlockFrequencyArgs struct {
+	BlockFrequency float64 `json:"blockFrequency"`
+}
+
+// SetBlockFrequency changes the block frequency from the next maint
+// timeslice, so the reply still shows the current frequency.
+func (h *RPC) SetBlockFrequency(r *http.Request, args *SetBlockFrequencyArgs, reply *ControlReply) error {
+	if err := authorize(r, "SetBlockFrequency"); err != nil {
+		return err
+	}
+	if err := ktime.SetBlockFrequency(args.BlockFrequency); err != nil {
+		return err
+	}
+	getStatus(&reply.Status)
+	return nil
+}
+
+type KillProcessArgs struct {
+	PID uint `json:"pid"`
+}
+
+func (h *RPC) KillProcess(r *http.Request, args *KillProcessArgs, reply *ControlReply) error {
+	if err := authorize(r, "KillProcess"); err != nil {
+		return err
+	}
+	if !proc.IsScheduled(args.PID) {
+		return fmt.Errorf("no process with pid %d", args.PID)
+	}
+	proc.Kill(args.PID)
+	getStatus(&reply.Status)
+	return nil
+}
+
+type GetConfigArgs struct {
+}
+
+type GetConfigReply struct {
+	Config string `json:"config"`
+}
+
+func (h *RPC) GetConfig(r *http.Request, args *GetConfigArgs, reply *GetConfigReply) error {
+	if err := authorize(r, "GetConfig"); err != nil {
+		return err
+	}
+	reply.Config = Config().String()
+	return nil
+}
+
+// UpdateConfigArgs holds settings by their KernelConfig.FromViper keys,
+// such as "kernel.blockFrequency".
+type UpdateConfigArgs struct {
+	Settings map[string]interface{} `json:"settings"`
+}
+
+type UpdateConfigReply struct {
+	Changes []ConfigChange `json:"changes"`
+}
+
+// UpdateConfig schedules the settings to take effect at the next maint
+// timeslice.
+func (h *RPC) UpdateConfig(r *http.Request, args *UpdateConfigArgs, reply *UpdateConfigReply) error {
+	if err := authorize(r, "UpdateConfig"); err != nil {
+		return err
+	}
+	v := viper.New()
+	for key, value := range args.Settings {
+		v.Set(key, value)
+	}
+	c := Config()
+	if err := c.FromViper(v); err != nil {
+		return err
+	}
+	changes, err := UpdateConfig(c)
+	if err != nil {
+		return err
+	}
+	reply.Changes = changes
+	return nil
+}
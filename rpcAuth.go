@@ -0,0 +1,265 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	gonet "net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Role is the level of access granted to an RPC caller.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleReader
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleReader:
+		return "reader"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// RPCAuthenticator determines the role of the caller of an RPC method
+// or HTTP handler.
+type RPCAuthenticator interface {
+	Authenticate(r *http.Request) (Role, error)
+}
+
+// rpcMethodRoles gives the role required by each RPC method. Methods
+// not listed require RoleAdmin.
+var rpcMethodRoles = map[string]Role{
+	"GetMetrics":        RoleReader,
+	"GetMetricsHistory": RoleReader,
+	"GetHealth":         RoleReader,
+	"GetStatus":         RoleReader,
+	"GetBranches":       RoleReader,
+	"GetBlockQueues":    RoleReader,
+	"GetProcesses":      RoleReader,
+	"StreamEvents":      RoleReader,
+	"StopCycle":         RoleAdmin,
+	"StartCycle":        RoleAdmin,
+	"PauseGeneration":   RoleAdmin,
+	"SetBlockFrequency": RoleAdmin,
+	"KillProcess":       RoleAdmin,
+	"GetConfig":         RoleReader,
+	"UpdateConfig":      RoleAdmin,
+}
+
+type rpcAccess struct {
+	auth  RPCAuthenticator
+	mu    sync.Mutex
+	audit io.Writer
+}
+
+var access *rpcAccess
+
+func initRPCAccess(c *KernelConfig) {
+	a := &rpcAccess{}
+	a.auth = c.RPCAuthenticator
+	if a.auth == nil {
+		a.auth = &LocalhostAuthenticator{Role: RoleAdmin}
+	}
+	a.audit = c.RPCAuditLog
+	access = a
+}
+
+// authorize checks that the caller of an RPC method has the role the
+// method requires.
+func authorize(r *http.Request, method string) error {
+	if !Initialized() {
+		return errors.New("kernel not initialized")
+	}
+	required, ok := rpcMethodRoles[method]
+	if !ok {
+		required = RoleAdmin
+	}
+	return access.check(r, method, required)
+}
+
+func (a *rpcAccess) check(r *http.Request, method string, required Role) error {
+	role, err := a.auth.Authenticate(r)
+	if err == nil && role < required {
+		err = fmt.Errorf("%s requires the %s role", method, required)
+	}
+	if err != nil {
+		a.log(r, method, role, false, err.Error())
+		return errors.New("access denied")
+	}
+	if required == RoleAdmin {
+		a.log(r, method, role, true, "")
+	}
+	return nil
+}
+
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Remote  string    `json:"remote"`
+	Role    string    `json:"role"`
+	Allowed bool      `json:"allowed"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// log records denied calls, and allowed calls to admin methods, in the
+// audit log.
+func (a *rpcAccess) log(r *http.Request, method string, role Role, allowed bool, reason string) {
+	if !allowed {
+		glog.Warningf("RPC %s from %s denied: %s", method, r.RemoteAddr, reason)
+	}
+	if a.audit == nil {
+		return
+	}
+	entry := &auditEntry{
+		Time:    time.Now().UTC(),
+		Method:  method,
+		Remote:  r.RemoteAddr,
+		Role:    role.String(),
+		Allowed: allowed,
+		Reason:  reason}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.audit.Write(append(line, '\n')); err != nil {
+		glog.Errorln("Failed to write RPC audit log:", err)
+	}
+}
+
+// RequireRole wraps an HTTP handler so that only callers with the role
+// reach it. Denied requests are recorded in the audit log.
+func RequireRole(role Role, name string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Initialized() {
+			http.Error(w, "kernel not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		if err := access.check(r, name, role); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// BearerTokenAuthenticator grants a role to requests that carry the
+// matching token in an "Authorization: Bearer" header.
+type BearerTokenAuthenticator struct {
+	ReaderToken string
+	AdminToken  string
+}
+
+func (a *BearerTokenAuthenticator) Authenticate(r *http.Request) (Role, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return RoleNone, errors.New("missing bearer token")
+	}
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+	if a.AdminToken != "" && subtle.ConstantTimeCompare(token, []byte(a.AdminToken)) == 1 {
+		return RoleAdmin, nil
+	}
+	if a.ReaderToken != "" && subtle.ConstantTimeCompare(token, []byte(a.ReaderToken)) == 1 {
+		return RoleReader, nil
+	}
+	return RoleNone, errors.New("invalid bearer token")
+}
+
+// ClientCertAuthenticator grants a role to requests made over TLS with a
+// client certificate verified by the server. Certificates whose common
+// name is listed in AdminNames receive RoleAdmin, others RoleReader.
+// The server must be configured to verify client certificates, as by
+// NewRPCTLSConfig.
+type ClientCertAuthenticator struct {
+	AdminNames []string
+}
+
+func (a *ClientCertAuthenticator) Authenticate(r *http.Request) (Role, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return RoleNone, errors.New("no verified client certificate")
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	for _, admin := range a.AdminNames {
+		if name == admin {
+			return RoleAdmin, nil
+		}
+	}
+	return RoleReader, nil
+}
+
+// NewRPCTLSConfig makes a server TLS configuration from local PEM files
+// that requires clients to present a certificate signed by a CA in
+// clientCAFile.
+func NewRPCTLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12}, nil
+}
+
+// LocalhostAuthenticator grants Role to requests from a loopback address
+// and denies all others. It is used when no authenticator is configured.
+type LocalhostAuthenticator struct {
+	Role Role
+}
+
+func (a *LocalhostAuthenticator) Authenticate(r *http.Request) (Role, error) {
+	host, _, err := gonet.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := gonet.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return RoleNone, fmt.Errorf("%s is not a loopback address", host)
+	}
+	return a.Role, nil
+}
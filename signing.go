@@ -0,0 +1,128 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	spec "github.com/blocktop/go-spec"
+	"golang.org/x/crypto/ed25519"
+)
+
+// KeyStore supplies the keys used to sign and verify messages on a
+// MessageChannel.
+type KeyStore interface {
+	// NodeKey returns the key with which this node signs its messages.
+	NodeKey() ed25519.PrivateKey
+
+	// PeerKey returns the public key of the given peer, if known.
+	PeerKey(peerID string) (ed25519.PublicKey, bool)
+}
+
+// MemoryKeyStore is a KeyStore holding its keys in memory.
+type MemoryKeyStore struct {
+	nodeKey  ed25519.PrivateKey
+	peerKeys *sync.Map
+}
+
+// envelope flag bit set when a signature follows the header
+const flagSigned byte = 0x08
+
+func NewMemoryKeyStore(nodeKey ed25519.PrivateKey) *MemoryKeyStore {
+	s := &MemoryKeyStore{}
+	s.nodeKey = nodeKey
+	s.peerKeys = &sync.Map{} // [peerID]ed25519.PublicKey
+	return s
+}
+
+func (s *MemoryKeyStore) NodeKey() ed25519.PrivateKey {
+	return s.nodeKey
+}
+
+func (s *MemoryKeyStore) PeerKey(peerID string) (ed25519.PublicKey, bool) {
+	key, ok := s.peerKeys.Load(peerID)
+	if !ok {
+		return nil, false
+	}
+	return key.(ed25519.PublicKey), true
+}
+
+func (s *MemoryKeyStore) AddPeerKey(peerID string, key ed25519.PublicKey) {
+	s.peerKeys.Store(peerID, key)
+}
+
+func (s *MemoryKeyStore) RemovePeerKey(peerID string) {
+	s.peerKeys.Delete(peerID)
+}
+
+func (c *MessageChannel) sign(netMsg *spec.NetworkMessage, env *envelope, key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("%s: signing key is invalid", c.Protocol.String())
+	}
+	env.flags |= flagSigned
+	env.signature = ed25519.Sign(key, signingBytes(netMsg, env))
+	return nil
+}
+
+// verify checks the signature of an inbound message against the key
+// of the peer named in its From field. Channels without a KeyStore
+// accept every message.
+func (c *MessageChannel) verify(netMsg *spec.NetworkMessage) error {
+	if c.KeyStore == nil {
+		return nil
+	}
+	env, err := decodeEnvelope(netMsg.Data)
+	if err != nil {
+		return err
+	}
+	if env.flags&flagSigned == 0 {
+		return errors.New("message is not signed")
+	}
+	key, ok := c.KeyStore.PeerKey(netMsg.From)
+	if !ok {
+		return fmt.Errorf("no key for peer %s", netMsg.From)
+	}
+	if !ed25519.Verify(key, signingBytes(netMsg, env), env.signature) {
+		return fmt.Errorf("signature does not match peer %s", netMsg.From)
+	}
+	return nil
+}
+
+// signingBytes covers every field of the message that a receiver acts
+// on, so none can be altered without invalidating the signature. Each
+// variable-length field is prefixed with its length so that bytes
+// cannot be moved from one field to the next.
+func signingBytes(netMsg *spec.NetworkMessage, env *envelope) []byte {
+	var b bytes.Buffer
+	writeField(&b, []byte(netMsg.Protocol.String()))
+	writeField(&b, []byte(netMsg.From))
+	writeField(&b, []byte(netMsg.Hash))
+	b.Write(env.header())
+	writeField(&b, env.payload)
+	writeField(&b, netMsg.Links)
+	return b.Bytes()
+}
+
+func writeField(b *bytes.Buffer, field []byte) {
+	var n [binary.MaxVarintLen64]byte
+	b.Write(n[:binary.PutUvarint(n[:], uint64(len(field)))])
+	b.Write(field)
+}
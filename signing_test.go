@@ -0,0 +1,118 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/kerneltest"
+	spec "github.com/blocktop/go-spec"
+	"golang.org/x/crypto/ed25519"
+)
+
+// note is a message type for channels registered by tests.
+type note struct {
+	Text string `json:"text"`
+}
+
+func (n *note) Marshal() ([]byte, []byte, error) {
+	data, err := json.Marshal(n)
+	return data, nil, err
+}
+
+func (n *note) Unmarshal(data []byte, links []byte) error {
+	return json.Unmarshal(data, n)
+}
+
+func (n *note) Hash() string {
+	h := sha256.Sum256([]byte(n.Text))
+	return hex.EncodeToString(h[:])
+}
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func TestSignedChannel(t *testing.T) {
+	h := kerneltest.NewHarness("test", "node")
+	h.Init()
+	defer kernel.Stop()
+
+	_, nodeKey := newKey(t)
+	peerPub, peerKey := newKey(t)
+	_, otherKey := newKey(t)
+	keys := kernel.NewMemoryKeyStore(nodeKey)
+	keys.AddPeerKey("peer1", peerPub)
+
+	received := make([]string, 0)
+	c := kernel.NewMessageChannel(&note{}, func(netMsg *spec.NetworkMessage) {
+		received = append(received, netMsg.From)
+	})
+	c.KeyStore = keys
+	if err := kernel.Network().RegisterMessageChannel(c); err != nil {
+		t.Fatal(err)
+	}
+
+	deliver := func(text string, from string, key ed25519.PrivateKey, alter func(*spec.NetworkMessage)) {
+		netMsg, err := c.PeerMessage(&note{Text: text}, from, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alter != nil {
+			alter(netMsg)
+		}
+		h.Network.Deliver(netMsg)
+	}
+	deliver("signed", "peer1", peerKey, nil)
+	deliver("wrong key", "peer1", otherKey, nil)
+	deliver("unknown peer", "peer2", peerKey, nil)
+	deliver("spoofed", "peer1", peerKey, func(netMsg *spec.NetworkMessage) { netMsg.From = "peer2" })
+	deliver("altered", "peer1", peerKey, func(netMsg *spec.NetworkMessage) { netMsg.Hash = "altered" })
+
+	if _, err := h.Step(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || received[0] != "peer1" {
+		t.Fatalf("received messages from %v, want only the one signed by peer1", received)
+	}
+	rejected := kernel.Metrics().PeerTraffic("peer1").SignatureRejected + kernel.Metrics().PeerTraffic("peer2").SignatureRejected
+	if rejected != 4 {
+		t.Errorf("%d messages rejected, want 4", rejected)
+	}
+}
+
+func TestPeerMessageRequiresKey(t *testing.T) {
+	h := kerneltest.NewHarness("test", "node")
+	h.Init()
+	defer kernel.Stop()
+
+	_, nodeKey := newKey(t)
+	c := kernel.NewMessageChannel(&note{}, func(netMsg *spec.NetworkMessage) {})
+	c.KeyStore = kernel.NewMemoryKeyStore(nodeKey)
+	if _, err := c.PeerMessage(&note{Text: "unsigned"}, "peer1", nil); err == nil {
+		t.Error("encoded a message for a signed channel without a key")
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Span is one timed step of a block's passage through the kernel's
+// receive pipeline. IDs are hex encoded as in OpenTelemetry.
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	StartTime    int64 // unix nanos
+	EndTime      int64 // unix nanos
+	Attributes   map[string]string
+	Err          error
+}
+
+// SpanExporter receives the spans of finished block traces at the end
+// of each maint timeslice.
+type SpanExporter interface {
+	ExportSpans(spans []*Span) error
+	Shutdown() error
+}
+
+var errTraceAbandoned = errors.New("trace abandoned before block was added")
+
+// FileSpanExporter appends spans to a file in the OTLP/JSON encoding,
+// one ExportTraceServiceRequest per line, as read by the OpenTelemetry
+// Collector's otlpjsonfile receiver.
+type FileSpanExporter struct {
+	mu          sync.Mutex
+	file        *os.File
+	serviceName string
+}
+
+func NewFileSpanExporter(path string, serviceName string) (*FileSpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSpanExporter{file: f, serviceName: serviceName}, nil
+}
+
+func (e *FileSpanExporter) ExportSpans(spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.file == nil {
+		return errors.New("span exporter is shut down")
+	}
+
+	otlpSpans := make([]*otlpSpan, len(spans))
+	for i, s := range spans {
+		otlpSpans[i] = newOTLPSpan(s)
+	}
+	req := &otlpRequest{ResourceSpans: []*otlpResourceSpans{{
+		Resource: &otlpResource{Attributes: otlpAttributes(map[string]string{"service.name": e.serviceName})},
+		ScopeSpans: []*otlpScopeSpans{{
+			Scope: &otlpScope{Name: "github.com/blocktop/go-kernel"},
+			Spans: otlpSpans}}}}}
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = e.file.Write(append(line, '\n'))
+	return err
+}
+
+func (e *FileSpanExporter) Shutdown() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.file == nil {
+		return nil
+	}
+	err := e.file.Close()
+	e.file = nil
+	return err
+}
+
+// OTLP/JSON encoding of trace data.
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   *otlpResource     `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope *otlpScope  `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func newOTLPSpan(s *Span) *otlpSpan {
+	o := &otlpSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentSpanID,
+		Name:              s.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime, 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime, 10),
+		Attributes:        otlpAttributes(s.Attributes),
+		Status:            &otlpStatus{Code: otlpStatusOK}}
+	if s.Err != nil {
+		o.Status = &otlpStatus{Code: otlpStatusError, Message: s.Err.Error()}
+	}
+	return o
+}
+
+func otlpAttributes(attrs map[string]string) []*otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]*otlpKeyValue, len(keys))
+	for i, k := range keys {
+		kvs[i] = &otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: attrs[k]}}
+	}
+	return kvs
+}
@@ -0,0 +1,162 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	spec "github.com/blocktop/go-spec"
+	"github.com/golang/glog"
+)
+
+// KernelState is the kernel state that survives a restart.
+type KernelState struct {
+	GenNum          uint64 `json:"genNum,string"`
+	RootID          int    `json:"rootID"`
+	CycleNumber     uint64 `json:"cycleNumber,string"`
+	GenesisProduced bool   `json:"genesisProduced"`
+}
+
+// StateStore persists the kernel state. The kernel saves its state at
+// the end of every maint timeslice and restores it in Init. The state
+// refers to blocks of the blockchain, so it is of use only with a
+// blockchain that keeps its blocks across a restart; a saved state
+// whose blocks the consensus does not have is discarded.
+type StateStore interface {
+	// Load returns the saved state, or nil if none has been saved.
+	Load() (*KernelState, error)
+	Save(s *KernelState) error
+}
+
+// FileStateStore keeps the kernel state in a JSON file.
+type FileStateStore struct {
+	path string
+}
+
+func NewFileStateStore(path string) *FileStateStore {
+	return &FileStateStore{path: path}
+}
+
+func (f *FileStateStore) Load() (*KernelState, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := &KernelState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes the state to a temporary file and renames it over the
+// state file, so a crash leaves either the old or the new state.
+func (f *FileStateStore) Save(s *KernelState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (k *Kernel) restoreState() {
+	if k.config.StateStore == nil {
+		return
+	}
+	s, err := k.config.StateStore.Load()
+	if err != nil {
+		glog.Errorln("failed to load kernel state, starting afresh:", err)
+		return
+	}
+	if s == nil {
+		return
+	}
+	ktime.setCycleNumber(s.CycleNumber)
+
+	// The block state holds only if the blockchain kept the block
+	// generation was to follow. The competition is kept for the first
+	// proc, as maint would keep it.
+	blk.comp = blk.consensus.Evaluate()
+	if s.GenNum > 0 && !headFrom(blk.comp, s.GenNum-1) {
+		glog.Warningf("the blockchain has no head at block %d, discarding the saved block state", s.GenNum-1)
+		glog.Infof("restored kernel state at cycle %d", s.CycleNumber)
+		return
+	}
+	blk.genNum = s.GenNum
+	blk.genesisProduced = s.GenesisProduced
+	if blk.comp != nil {
+		if _, ok := blk.comp.Branches()[s.RootID]; ok {
+			blk.rootID = s.RootID
+		}
+	}
+	glog.Infof("restored kernel state at cycle %d, block %d, root %d", s.CycleNumber, blk.genNum, blk.rootID)
+}
+
+// headFrom reports whether a branch of the competition has its head at
+// or above the given block number. The saved block number is that of
+// the last block generated, or the next after genesis, and peer blocks
+// may have been added on top of it.
+func headFrom(comp spec.Competition, number uint64) bool {
+	if comp == nil {
+		return false
+	}
+	for _, branch := range comp.Branches() {
+		blocks := branch.Blocks()
+		if len(blocks) > 0 && blocks[0].BlockNumber() >= number {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *Kernel) saveState() {
+	if k.config.StateStore == nil {
+		return
+	}
+	s := &KernelState{
+		GenNum:          blk.genNum,
+		RootID:          blk.rootID,
+		CycleNumber:     ktime.CycleNumber(),
+		GenesisProduced: blk.genesisProduced}
+	if err := k.config.StateStore.Save(s); err != nil {
+		glog.Errorln("failed to save kernel state:", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/kerneltest"
+	spec "github.com/blocktop/go-spec"
+)
+
+// chainOf returns a competition of one branch whose head is at the
+// given block number.
+func chainOf(head uint64) *kerneltest.Competition {
+	blocks := make([]spec.Block, 0, head+1)
+	for n := int64(head); n >= 0; n-- {
+		blocks = append(blocks, kerneltest.NewBlock(uint64(n), "", nil))
+	}
+	return kerneltest.NewCompetition(&kerneltest.Branch{Root: 1, Chain: blocks})
+}
+
+func TestRestoreState(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		chain   *kerneltest.Competition
+		saved   uint64
+		restore bool
+	}{
+		{"chain at saved block", chainOf(6), 6, true},
+		{"chain after genesis", chainOf(0), 1, true},
+		{"chain beyond saved block", chainOf(9), 6, true},
+		{"chain shorter than saved block", chainOf(2), 20, false},
+		{"chain without blocks", nil, 6, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "kernelstate")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			store := kernel.NewFileStateStore(filepath.Join(dir, "state"))
+			saved := &kernel.KernelState{GenNum: test.saved, RootID: 1, CycleNumber: 40, GenesisProduced: true}
+			if err := store.Save(saved); err != nil {
+				t.Fatal(err)
+			}
+
+			h := kerneltest.NewHarness("test", "node")
+			if test.chain != nil {
+				h.Consensus.Script(test.chain)
+			}
+			h.Config.StateStore = store
+			h.Init()
+			defer kernel.Stop()
+
+			if c := kernel.Time().CycleNumber(); c != 40 {
+				t.Errorf("cycle %d, want the saved cycle 40", c)
+			}
+			restored := kernel.Block().BlockNumber() == test.saved && kernel.Block().RootID() == 1
+			if restored != test.restore {
+				t.Errorf("block %d root %d restored, want restored %v", kernel.Block().BlockNumber(), kernel.Block().RootID(), test.restore)
+			}
+		})
+	}
+}
+
+func TestRestoreEmptyState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kernelstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h := kerneltest.NewHarness("test", "node")
+	h.Config.StateStore = kernel.NewFileStateStore(path)
+	h.Init()
+	defer kernel.Stop()
+
+	if n := kernel.Block().BlockNumber(); n != 0 {
+		t.Errorf("block %d after an empty state file, want 0", n)
+	}
+}
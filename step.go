@@ -0,0 +1,182 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	spec "github.com/blocktop/go-spec"
+	"github.com/golang/glog"
+)
+
+// A stepped kernel runs no queue goroutines. Inbound messages, blocks
+// to add and held broadcasts are kept in slices that Step works through
+// in the caller's goroutine, so that every effect of a cycle is in its
+// report and no work of one kernel runs while another is active.
+
+func (n *KernelNet) isStepping() bool {
+	n.stepMu.Lock()
+	defer n.stepMu.Unlock()
+	return n.stepping
+}
+
+func (n *KernelNet) beginStep() {
+	n.stepMu.Lock()
+	n.stepping = true
+	n.stepMu.Unlock()
+
+	n.announceVersions()
+}
+
+// endStep passes the messages held for Step, or held since Init, to the
+// receive queues.
+func (n *KernelNet) endStep() {
+	n.stepMu.Lock()
+	msgs := append(n.delayed, n.inbox...)
+	n.inbox = nil
+	n.delayed = nil
+	n.stepping = false
+	n.stepMu.Unlock()
+
+	for _, netMsg := range msgs {
+		n.enqueue(netMsg)
+	}
+}
+
+// hold keeps an inbound message for Step, reporting false if the kernel
+// is not stepped.
+func (n *KernelNet) hold(netMsg *spec.NetworkMessage) bool {
+	n.stepMu.Lock()
+	defer n.stepMu.Unlock()
+	if !n.stepping {
+		return false
+	}
+	if len(n.inbox) >= n.recvQCap() {
+		metrics.addDropped(netMsg)
+		glog.Warningf("%s: receive queue full, dropped %s message from %s", ktime.String(), netMsg.Protocol.String(), netMsg.From)
+		return true
+	}
+	n.inbox = append(n.inbox, netMsg)
+	return true
+}
+
+// delay keeps an inbound message until the next Step, reporting false
+// if the kernel is not stepped.
+func (n *KernelNet) delay(netMsg *spec.NetworkMessage) bool {
+	n.stepMu.Lock()
+	defer n.stepMu.Unlock()
+	if !n.stepping {
+		return false
+	}
+	n.delayed = append(n.delayed, netMsg)
+	return true
+}
+
+func (n *KernelNet) releaseDelayed() {
+	n.stepMu.Lock()
+	msgs := n.delayed
+	n.delayed = nil
+	n.stepMu.Unlock()
+
+	for _, netMsg := range msgs {
+		n.enqueue(netMsg)
+	}
+}
+
+// deliverStep passes every held inbound message to its channel.
+func (n *KernelNet) deliverStep() {
+	for {
+		n.stepMu.Lock()
+		msgs := n.inbox
+		n.inbox = nil
+		n.stepMu.Unlock()
+		if len(msgs) == 0 {
+			return
+		}
+
+		for _, netMsg := range msgs {
+			tracer.begin(netMsg)
+			c, ok := n.channels.Load(netMsg.Protocol.String())
+			if !ok {
+				metrics.addUnknownProtocol(netMsg)
+				tracer.end(netMsg, errors.New("channel was unregistered"))
+				continue
+			}
+			n.receive(c.(*MessageChannel), netMsg)
+		}
+	}
+}
+
+// endStepProc sends the broadcasts held during proc.
+func (n *KernelNet) endStepProc() {
+	n.stepMu.Lock()
+	held := n.held
+	n.held = nil
+	n.stepMu.Unlock()
+
+	glog.V(3).Infof("%s: resuming message broadcasts, sending %d held messages", ktime.String(), len(held))
+	reporter.update(func(c *CycleReport) { c.BroadcastsReleased = len(held) })
+	n.holdBroadcasts = false
+	if len(held) == 0 {
+		return
+	}
+
+	netMsgs := make([]*spec.NetworkMessage, len(held))
+	for i, h := range held {
+		netMsgs[i] = h.netMsg
+	}
+	err := n.sendBroadcast(netMsgs)
+	if err != nil {
+		glog.Errorln(err)
+	}
+	for _, h := range held {
+		h.future.resolve(err)
+	}
+}
+
+// stepProc is the proc timeslice of a stepped kernel. Rather than
+// waiting out the computed proc time, it handles every held message
+// and block, then generates. If the context is done between phases,
+// the remaining phases are skipped and its error returned.
+func (k *Kernel) stepProc(ctx context.Context) error {
+	procStartTime := time.Now().UnixNano()
+
+	net.beginProc()
+	net.releaseDelayed()
+
+	procTime := metrics.computeProcTime()
+	reporter.update(func(c *CycleReport) { c.ComputedProcTime = procTime })
+
+	err := ctx.Err()
+	if err == nil {
+		net.deliverStep()
+		blk.blockQs.processStep()
+		err = ctx.Err()
+	}
+	if err == nil {
+		blk.generate()
+	}
+
+	net.endProc()
+
+	actualProcTime := time.Now().UnixNano() - procStartTime
+	metrics.setActualProcTime(actualProcTime)
+	reporter.update(func(c *CycleReport) { c.ProcTime = time.Duration(actualProcTime) })
+	return err
+}
@@ -17,21 +17,27 @@
 package kernel
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/golang/glog"
 )
 
 type KernelTime struct {
 	blockFrequency float64
 	blockInterval  time.Duration
 	intervalLen    string
-	cycleNumber    uint64
-	startTime      int64
-	cycleStartTime int64
+	cycleNumber    uint64 // atomic
+	startTime      int64  // atomic
+	cycleStartTime int64  // atomic
+	mu             sync.Mutex
+	nextFrequency  float64
 }
 
 var ktime *KernelTime
@@ -43,16 +49,46 @@ func initTime(blockFrequency float64) {
 
 func (t *KernelTime) setBlockFrequency(rate float64) {
 	interval := time.Duration(float64(time.Second) / rate)
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.blockFrequency = rate
 	t.blockInterval = interval
 	t.intervalLen = strconv.FormatInt(int64(len(strconv.FormatInt(int64(interval), 10))), 10)
 }
 
+// SetBlockFrequency changes the block frequency, in blocks per second,
+// from the next maint timeslice.
+func (t *KernelTime) SetBlockFrequency(rate float64) error {
+	if rate <= 0 {
+		return errors.New("block frequency must be positive")
+	}
+	t.mu.Lock()
+	t.nextFrequency = rate
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *KernelTime) maint() {
+	t.mu.Lock()
+	rate := t.nextFrequency
+	t.nextFrequency = 0
+	t.mu.Unlock()
+
+	if rate > 0 && rate != t.BlockFrequency() {
+		glog.Infof("%s: block frequency changed from %v to %v", t.String(), t.BlockFrequency(), rate)
+		t.setBlockFrequency(rate)
+	}
+}
+
 func (t *KernelTime) BlockFrequency() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return t.blockFrequency
 }
 
 func (t *KernelTime) BlockInterval() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return t.blockInterval
 }
 
@@ -61,29 +97,47 @@ func (t *KernelTime) UpTime() time.Duration {
 }
 
 func (t *KernelTime) CycleNumber() uint64 {
-	return t.cycleNumber
+	return atomic.LoadUint64(&t.cycleNumber)
+}
+
+func (t *KernelTime) setCycleNumber(cycle uint64) {
+	atomic.StoreUint64(&t.cycleNumber, cycle)
 }
 
 func (t *KernelTime) Nanos() int64 {
-	return time.Now().UnixNano() - t.startTime
+	return time.Now().UnixNano() - atomic.LoadInt64(&t.startTime)
 }
 
 func (t *KernelTime) String() string {
-	scycle := humanize.Comma(int64(t.CycleNumber()))
-	cycleTime := (time.Now().UnixNano() - t.cycleStartTime) / 1000
-	return fmt.Sprintf("%s.%s", scycle, leftPadZeroes(cycleTime, 6))
+	cycle, offset := t.cycleAndOffset()
+	scycle := humanize.Comma(int64(cycle))
+	return fmt.Sprintf("%s.%s", scycle, leftPadZeroes(int64(offset/time.Microsecond), 6))
+}
+
+// cycleOffset is the time since the current cycle started.
+func (t *KernelTime) cycleOffset() time.Duration {
+	return time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&t.cycleStartTime))
+}
+
+// cycleAndOffset returns the cycle number and the time since the cycle
+// started. The start time is stored before the number, so the offset
+// may belong to the cycle after the one returned but never to one
+// before it.
+func (t *KernelTime) cycleAndOffset() (uint64, time.Duration) {
+	cycle := t.CycleNumber()
+	return cycle, t.cycleOffset()
 }
 
 func (t *KernelTime) up() {
-	t.startTime = time.Now().UnixNano()
+	atomic.StoreInt64(&t.startTime, time.Now().UnixNano())
 }
 
 func (t *KernelTime) startCycle() {
 	now := time.Now().UnixNano()
-	cycleTime := now - t.cycleStartTime
+	cycleTime := now - atomic.LoadInt64(&t.cycleStartTime)
 	metrics.setCycleTime(cycleTime)
-	t.cycleNumber++
-	t.cycleStartTime = now
+	atomic.StoreInt64(&t.cycleStartTime, now)
+	atomic.AddUint64(&t.cycleNumber, 1)
 }
 
 func leftPadZeroes(val int64, overallLen int) string {
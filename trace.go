@@ -0,0 +1,214 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+
+	spec "github.com/blocktop/go-spec"
+	"github.com/golang/glog"
+)
+
+// Stages of the pipeline through which a block received from a peer
+// passes. Each stage is timestamped when the block reaches it.
+const (
+	stageReceived  = "received"  // handed to the kernel by the network node
+	stageDequeued  = "dequeued"  // taken from the protocol's receive queue
+	stageQueued    = "queued"    // put in the block queue of its parent
+	stageBatched   = "batched"   // taken from the block queue in a batch
+	stageAdded     = "added"     // returned from Blockchain.AddBlocks
+	stageBroadcast = "broadcast" // re-broadcast to peers
+)
+
+// Names of the intervals between pipeline stages, keyed by the stage
+// that ends them. Stage latency metrics are kept under these names.
+var pipelineIntervals = map[string]string{
+	stageDequeued:  "receive_queue",
+	stageQueued:    "unmarshal",
+	stageBatched:   "block_queue",
+	stageAdded:     "add_blocks",
+	stageBroadcast: "rebroadcast",
+}
+
+const pipelineTotal = "total"
+
+var pipelineIntervalNames = []string{"receive_queue", "unmarshal", "block_queue",
+	"add_blocks", "rebroadcast", pipelineTotal}
+
+// traceMaxCycles is the number of cycles after which a trace that has
+// not finished, such as that of a block whose parent never arrives, is
+// abandoned.
+const traceMaxCycles = 1000
+
+type blockTracer struct {
+	protocol string
+	traces   *sync.Map // [*spec.NetworkMessage]*blockTrace
+	exporter SpanExporter
+	mu       sync.Mutex
+	spans    []*Span
+}
+
+type blockTrace struct {
+	mu     sync.Mutex
+	id     string
+	hash   string
+	from   string
+	cycle  uint64
+	stages []traceStage
+}
+
+type traceStage struct {
+	name string
+	time int64
+}
+
+var tracer *blockTracer
+
+func initTrace(c *KernelConfig) {
+	t := &blockTracer{}
+	t.traces = &sync.Map{}
+	t.exporter = c.SpanExporter
+	t.spans = make([]*Span, 0)
+	tracer = t
+}
+
+func (t *blockTracer) begin(netMsg *spec.NetworkMessage) {
+	if netMsg.Protocol == nil || netMsg.Protocol.String() != t.protocol {
+		return
+	}
+	tr := &blockTrace{id: newTraceID(), hash: netMsg.Hash, from: netMsg.From, cycle: ktime.CycleNumber()}
+	tr.stages = []traceStage{{stageReceived, time.Now().UnixNano()}}
+	t.traces.Store(netMsg, tr)
+}
+
+func (t *blockTracer) mark(netMsg *spec.NetworkMessage, stage string) {
+	tr, ok := t.traces.Load(netMsg)
+	if !ok {
+		return
+	}
+	trace := tr.(*blockTrace)
+	trace.mu.Lock()
+	trace.stages = append(trace.stages, traceStage{stage, time.Now().UnixNano()})
+	trace.mu.Unlock()
+}
+
+// end finishes the trace of a message, recording the latency of each
+// stage it reached. A non-nil err records why the block left the
+// pipeline early.
+func (t *blockTracer) end(netMsg *spec.NetworkMessage, err error) {
+	tr, ok := t.traces.Load(netMsg)
+	if !ok {
+		return
+	}
+	t.traces.Delete(netMsg)
+	t.finish(tr.(*blockTrace), err)
+}
+
+func (t *blockTracer) finish(trace *blockTrace, err error) {
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+
+	for i := 1; i < len(trace.stages); i++ {
+		interval, ok := pipelineIntervals[trace.stages[i].name]
+		if ok {
+			metrics.setStageLatency(interval, trace.stages[i].time-trace.stages[i-1].time)
+		}
+	}
+	first, last := trace.stages[0], trace.stages[len(trace.stages)-1]
+	if err == nil {
+		metrics.setStageLatency(pipelineTotal, last.time-first.time)
+	}
+
+	if t.exporter != nil {
+		t.mu.Lock()
+		t.spans = append(t.spans, trace.spans(err)...)
+		t.mu.Unlock()
+	}
+}
+
+// maint abandons stale traces and hands finished spans to the exporter.
+func (t *blockTracer) maint() {
+	cycle := ktime.CycleNumber()
+	t.traces.Range(func(m, tr interface{}) bool {
+		trace := tr.(*blockTrace)
+		if cycle-trace.cycle > traceMaxCycles {
+			t.end(m.(*spec.NetworkMessage), errTraceAbandoned)
+		}
+		return true
+	})
+
+	if t.exporter == nil {
+		return
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = make([]*Span, 0)
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.exporter.ExportSpans(spans); err != nil {
+		glog.Errorln("Failed to export block pipeline spans:", err)
+	}
+}
+
+func (trace *blockTrace) spans(err error) []*Span {
+	first, last := trace.stages[0], trace.stages[len(trace.stages)-1]
+	root := &Span{
+		TraceID:   trace.id,
+		SpanID:    newSpanID(),
+		Name:      "block",
+		StartTime: first.time,
+		EndTime:   last.time,
+		Attributes: map[string]string{
+			"block.hash": trace.hash,
+			"peer.from":  trace.from,
+			"stage.last": last.name},
+		Err: err}
+
+	spans := []*Span{root}
+	for i := 1; i < len(trace.stages); i++ {
+		interval, ok := pipelineIntervals[trace.stages[i].name]
+		if !ok {
+			continue
+		}
+		spans = append(spans, &Span{
+			TraceID:      trace.id,
+			SpanID:       newSpanID(),
+			ParentSpanID: root.SpanID,
+			Name:         interval,
+			StartTime:    trace.stages[i-1].time,
+			EndTime:      trace.stages[i].time})
+	}
+	return spans
+}
+
+func newTraceID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func newSpanID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
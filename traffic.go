@@ -0,0 +1,305 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	spec "github.com/blocktop/go-spec"
+)
+
+// TrafficCounts totals the network traffic of a peer or protocol.
+// Broadcasts are not addressed to particular peers, so outbound
+// traffic is counted only by protocol.
+type TrafficCounts struct {
+	MessagesIn        uint64 `json:"messagesIn,string"`
+	BytesIn           uint64 `json:"bytesIn,string"`
+	MessagesOut       uint64 `json:"messagesOut,string"`
+	BytesOut          uint64 `json:"bytesOut,string"`
+	Dropped           uint64 `json:"dropped,string"`
+	UnknownProtocol   uint64 `json:"unknownProtocol,string"`
+	HashMismatch      uint64 `json:"hashMismatch,string"`
+	SignatureRejected uint64 `json:"signatureRejected,string"`
+}
+
+// PeerTraffic is the traffic received from one peer.
+type PeerTraffic struct {
+	Peer string `json:"peer"`
+	TrafficCounts
+}
+
+// TrafficOther names the traffic counts of the peers not counted
+// separately, and of the protocols of no registered channel.
+const TrafficOther = "(other)"
+
+type peerTrafficCounts struct {
+	TrafficCounts
+	lastSeen     uint64 // atomic, cycle number
+	lastAccepted uint64 // atomic, cycle number, 0 if never
+}
+
+// traffic is keyed by the unauthenticated From field of messages, so
+// its maps are bounded.
+type traffic struct {
+	mu        sync.RWMutex
+	peers     map[string]*peerTrafficCounts
+	other     *TrafficCounts
+	protocols *sync.Map // [protocol]*TrafficCounts
+	maxPeers  int
+	cycles    uint64 // after which a peer not heard from is evicted
+	topN      int32  // atomic
+}
+
+func newTraffic() *traffic {
+	t := &traffic{}
+	t.peers = make(map[string]*peerTrafficCounts)
+	t.other = &TrafficCounts{}
+	t.protocols = &sync.Map{}
+	return t
+}
+
+// setLimits sets KernelConfig.MaxTrafficPeers, TrafficPeerCycles and
+// TrafficTopN.
+func (t *traffic) setLimits(maxPeers int, cycles int, topN int) {
+	t.mu.Lock()
+	t.maxPeers = maxPeers
+	t.cycles = uint64(cycles)
+	t.mu.Unlock()
+	atomic.StoreInt32(&t.topN, int32(topN))
+}
+
+func (t *traffic) topPeerCount() int {
+	return int(atomic.LoadInt32(&t.topN))
+}
+
+// peer returns the counts of the peer, or nil if the peer is not counted
+// separately.
+func (t *traffic) peer(peerID string) *peerTrafficCounts {
+	t.mu.RLock()
+	c, ok := t.peers[peerID]
+	t.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.peers[peerID]; ok {
+		return c
+	}
+	if len(t.peers) >= t.maxPeers {
+		return nil
+	}
+	c = &peerTrafficCounts{}
+	t.peers[peerID] = c
+	return c
+}
+
+func (t *traffic) peerCounts(peerID string) *TrafficCounts {
+	if c := t.peer(peerID); c != nil {
+		return &c.TrafficCounts
+	}
+	return t.other
+}
+
+// protocol returns the counts of the protocol, which are kept only for
+// protocols of registered channels.
+func (t *traffic) protocol(protocol string) *TrafficCounts {
+	if net == nil || net.protocol(protocol) == nil {
+		protocol = TrafficOther
+	}
+	c, _ := t.protocols.LoadOrStore(protocol, &TrafficCounts{})
+	return c.(*TrafficCounts)
+}
+
+// each applies f to the counts of the message's sender and protocol.
+func (t *traffic) each(netMsg *spec.NetworkMessage, f func(c *TrafficCounts)) {
+	f(t.peerCounts(netMsg.From))
+	if netMsg.Protocol != nil {
+		f(t.protocol(netMsg.Protocol.String()))
+	}
+}
+
+// evict folds the counts of peers not heard from within
+// KernelConfig.TrafficPeerCycles into TrafficOther.
+func (t *traffic) evict() {
+	now := ktime.CycleNumber()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for peerID, c := range t.peers {
+		if now-atomic.LoadUint64(&c.lastSeen) > t.cycles {
+			t.other.add(c.load())
+			delete(t.peers, peerID)
+		}
+	}
+}
+
+func (m *KernelMetrics) evictPeers() {
+	m.traffic.evict()
+}
+
+func messageSize(netMsg *spec.NetworkMessage) uint64 {
+	return uint64(len(netMsg.Data) + len(netMsg.Links))
+}
+
+func (c *TrafficCounts) load() TrafficCounts {
+	return TrafficCounts{
+		MessagesIn:        atomic.LoadUint64(&c.MessagesIn),
+		BytesIn:           atomic.LoadUint64(&c.BytesIn),
+		MessagesOut:       atomic.LoadUint64(&c.MessagesOut),
+		BytesOut:          atomic.LoadUint64(&c.BytesOut),
+		Dropped:           atomic.LoadUint64(&c.Dropped),
+		UnknownProtocol:   atomic.LoadUint64(&c.UnknownProtocol),
+		HashMismatch:      atomic.LoadUint64(&c.HashMismatch),
+		SignatureRejected: atomic.LoadUint64(&c.SignatureRejected)}
+}
+
+func (c *TrafficCounts) add(d TrafficCounts) {
+	atomic.AddUint64(&c.MessagesIn, d.MessagesIn)
+	atomic.AddUint64(&c.BytesIn, d.BytesIn)
+	atomic.AddUint64(&c.MessagesOut, d.MessagesOut)
+	atomic.AddUint64(&c.BytesOut, d.BytesOut)
+	atomic.AddUint64(&c.Dropped, d.Dropped)
+	atomic.AddUint64(&c.UnknownProtocol, d.UnknownProtocol)
+	atomic.AddUint64(&c.HashMismatch, d.HashMismatch)
+	atomic.AddUint64(&c.SignatureRejected, d.SignatureRejected)
+}
+
+func (c TrafficCounts) summary() string {
+	return fmt.Sprintf("%d/%d msgs, %d/%d bytes, %d, %d, %d, %d",
+		c.MessagesIn, c.MessagesOut, c.BytesIn, c.BytesOut,
+		c.Dropped, c.UnknownProtocol, c.HashMismatch, c.SignatureRejected)
+}
+
+func (m *KernelMetrics) addMessageIn(netMsg *spec.NetworkMessage) {
+	size := messageSize(netMsg)
+	if c := m.traffic.peer(netMsg.From); c != nil {
+		atomic.StoreUint64(&c.lastSeen, ktime.CycleNumber())
+	}
+	m.traffic.each(netMsg, func(c *TrafficCounts) {
+		atomic.AddUint64(&c.MessagesIn, 1)
+		atomic.AddUint64(&c.BytesIn, size)
+	})
+}
+
+func (m *KernelMetrics) addMessagesOut(netMsgs []*spec.NetworkMessage) {
+	for _, netMsg := range netMsgs {
+		c := m.traffic.protocol(netMsg.Protocol.String())
+		atomic.AddUint64(&c.MessagesOut, 1)
+		atomic.AddUint64(&c.BytesOut, messageSize(netMsg))
+	}
+}
+
+func (m *KernelMetrics) addDropped(netMsg *spec.NetworkMessage) {
+	m.traffic.each(netMsg, func(c *TrafficCounts) { atomic.AddUint64(&c.Dropped, 1) })
+}
+
+func (m *KernelMetrics) addUnknownProtocol(netMsg *spec.NetworkMessage) {
+	m.traffic.each(netMsg, func(c *TrafficCounts) { atomic.AddUint64(&c.UnknownProtocol, 1) })
+}
+
+func (m *KernelMetrics) addHashMismatch(netMsg *spec.NetworkMessage) {
+	m.traffic.each(netMsg, func(c *TrafficCounts) { atomic.AddUint64(&c.HashMismatch, 1) })
+}
+
+func (m *KernelMetrics) addSignatureRejected(netMsg *spec.NetworkMessage) {
+	m.traffic.each(netMsg, func(c *TrafficCounts) { atomic.AddUint64(&c.SignatureRejected, 1) })
+}
+
+// addAccepted records that a message from the sender passed signature
+// verification, where its channel signs, and unmarshalled to an item
+// matching its hash.
+func (m *KernelMetrics) addAccepted(netMsg *spec.NetworkMessage) {
+	if c := m.traffic.peer(netMsg.From); c != nil {
+		atomic.StoreUint64(&c.lastAccepted, ktime.CycleNumber())
+	}
+}
+
+// PeerTraffic returns the traffic of the peer. The traffic of peers not
+// counted separately is returned for TrafficOther.
+func (m *KernelMetrics) PeerTraffic(peerID string) TrafficCounts {
+	if peerID == TrafficOther {
+		return m.traffic.other.load()
+	}
+	m.traffic.mu.RLock()
+	defer m.traffic.mu.RUnlock()
+	c, ok := m.traffic.peers[peerID]
+	if !ok {
+		return TrafficCounts{}
+	}
+	return c.load()
+}
+
+func (m *KernelMetrics) ProtocolTrafficMap() map[string]TrafficCounts {
+	res := make(map[string]TrafficCounts)
+	m.traffic.protocols.Range(func(p, c interface{}) bool {
+		res[p.(string)] = c.(*TrafficCounts).load()
+		return true
+	})
+	return res
+}
+
+// ActivePeers returns the number of peers from which a message was
+// accepted within the given number of cycles. The sender of a message
+// is authenticated only on channels with a KeyStore.
+func (m *KernelMetrics) ActivePeers(cycles uint64) int {
+	now := ktime.CycleNumber()
+	count := 0
+	m.traffic.mu.RLock()
+	defer m.traffic.mu.RUnlock()
+	for _, c := range m.traffic.peers {
+		accepted := atomic.LoadUint64(&c.lastAccepted)
+		if accepted > 0 && now-accepted <= cycles {
+			count++
+		}
+	}
+	return count
+}
+
+// TopPeers returns the n peers that have sent the most bytes.
+func (m *KernelMetrics) TopPeers(n int) []PeerTraffic {
+	peers := m.peerTraffic()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].BytesIn > peers[j].BytesIn })
+	return firstPeers(peers, n)
+}
+
+// QuietestPeers returns the n peers that have sent the fewest bytes.
+func (m *KernelMetrics) QuietestPeers(n int) []PeerTraffic {
+	peers := m.peerTraffic()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].BytesIn < peers[j].BytesIn })
+	return firstPeers(peers, n)
+}
+
+func (m *KernelMetrics) peerTraffic() []PeerTraffic {
+	m.traffic.mu.RLock()
+	defer m.traffic.mu.RUnlock()
+	peers := make([]PeerTraffic, 0, len(m.traffic.peers))
+	for p, c := range m.traffic.peers {
+		peers = append(peers, PeerTraffic{Peer: p, TrafficCounts: c.load()})
+	}
+	return peers
+}
+
+func firstPeers(peers []PeerTraffic, n int) []PeerTraffic {
+	if len(peers) > n {
+		return peers[:n]
+	}
+	return peers
+}
@@ -0,0 +1,78 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build go1.18
+// +build go1.18
+
+package kernel
+
+import (
+	"fmt"
+	"reflect"
+
+	spec "github.com/blocktop/go-spec"
+	"github.com/golang/glog"
+)
+
+// TypedMessageChannel is a MessageChannel whose handler receives values
+// of the channel's item type. T must be a pointer type; the channel's
+// prototype is a new instance of the type it points to.
+type TypedMessageChannel[T spec.Marshalled] struct {
+	*MessageChannel
+}
+
+// TypedMessageReceiver handles an inbound message that has been
+// unmarshalled and checked against the message hash.
+type TypedMessageReceiver[T spec.Marshalled] func(item T, netMsg *spec.NetworkMessage)
+
+func NewTypedMessageChannel[T spec.Marshalled](receiveHandler TypedMessageReceiver[T]) (*TypedMessageChannel[T], error) {
+	var zero T
+	t := reflect.TypeOf(&zero).Elem()
+	if t.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("message channel item type %s is not a pointer", t.String())
+	}
+	prototype, ok := reflect.New(t.Elem()).Interface().(spec.Marshalled)
+	if !ok {
+		return nil, fmt.Errorf("message channel item type %s does not implement spec.Marshalled", t.String())
+	}
+
+	c := &TypedMessageChannel[T]{}
+	c.MessageChannel = NewMessageChannel(prototype, func(netMsg *spec.NetworkMessage) {
+		item, err := c.unmarshal(netMsg)
+		if err != nil {
+			glog.Warningf("Failed to unmarshal %s message from %s: %v", c.Protocol.String(), netMsg.From, err)
+			return
+		}
+		if item.Hash() != netMsg.Hash {
+			metrics.addHashMismatch(netMsg)
+			glog.Warningf("%s message data does not match message hash from %s", c.Protocol.String(), netMsg.From)
+			return
+		}
+		receiveHandler(item.(T), netMsg)
+	})
+	return c, nil
+}
+
+// Broadcast marshals the item into a message of the channel's protocol
+// and broadcasts it, subject to the checks of KernelNet.Broadcast. The
+// channel must be registered.
+func (c *TypedMessageChannel[T]) Broadcast(item T) (*BroadcastFuture, error) {
+	netMsg, err := c.message(item)
+	if err != nil {
+		return nil, err
+	}
+	return net.Broadcast(netMsg)
+}
@@ -0,0 +1,56 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build go1.18
+// +build go1.18
+
+package kernel_test
+
+import (
+	"testing"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/kerneltest"
+	spec "github.com/blocktop/go-spec"
+)
+
+func TestTypedBroadcastRequiresRegistration(t *testing.T) {
+	h := kerneltest.NewHarness("test", "node")
+	h.Init()
+	defer kernel.Stop()
+
+	c, err := kernel.NewTypedMessageChannel(func(item *note, netMsg *spec.NetworkMessage) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Broadcast(&note{Text: "unregistered"}); err == nil {
+		t.Error("broadcast on a channel that was never registered")
+	}
+
+	if err := kernel.Network().RegisterMessageChannel(c.MessageChannel); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Broadcast(&note{Text: "registered"}); err != nil {
+		t.Errorf("broadcast on a registered channel failed: %v", err)
+	}
+
+	if err := kernel.Network().UnregisterMessageChannel(c.MessageChannel); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Broadcast(&note{Text: "unregistered"}); err == nil {
+		t.Error("broadcast on an unregistered channel")
+	}
+}
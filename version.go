@@ -0,0 +1,270 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	spec "github.com/blocktop/go-spec"
+	"github.com/golang/glog"
+)
+
+// versionAnnouncement is the handshake message through which a node
+// tells its peers which format versions each of its channels supports.
+// A node announces when the kernel starts, when its channels change and
+// after a peer first announces to it, so that new peers learn its
+// versions in return. Announcements made for the latter two reasons are
+// sent at most once per cycle, at maint.
+//
+// Announcing also tells peers that the node understands envelopes. Only
+// peers whose announcements are accepted are tracked, so a message with
+// an unknown or forged sender cannot change how the node sends. The
+// kernel sends envelopes while any tracked peer has been heard from
+// recently; until then it sends bare data that nodes predating
+// versioning can read. Such nodes cannot read the envelopes of a
+// network that has begun versioning, and should be upgraded.
+type versionAnnouncement struct {
+	Peer     string              `json:"peer"`
+	Versions map[string][]uint16 `json:"versions"`
+}
+
+func (a *versionAnnouncement) Marshal() ([]byte, []byte, error) {
+	data, err := json.Marshal(a)
+	return data, nil, err
+}
+
+func (a *versionAnnouncement) Unmarshal(data []byte, links []byte) error {
+	return json.Unmarshal(data, a)
+}
+
+func (a *versionAnnouncement) Hash() string {
+	data, _ := json.Marshal(a)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type versionPeer struct {
+	versions map[string][]uint16
+	lastSeen uint64
+}
+
+type peerVersions struct {
+	sync.Mutex
+	peers     map[string]*versionPeer
+	maxPeers  int
+	cycles    uint64 // after which a peer not heard from is forgotten
+	envelopes int32  // atomic, 1 while any peer is tracked
+	announce  int32  // atomic, 1 when an announcement is due at maint
+}
+
+func newPeerVersions() *peerVersions {
+	v := &peerVersions{}
+	v.peers = make(map[string]*versionPeer)
+	return v
+}
+
+// setLimits sets KernelConfig.MaxVersionPeers and VersionPeerCycles.
+func (v *peerVersions) setLimits(maxPeers int, cycles int) {
+	v.Lock()
+	defer v.Unlock()
+	v.maxPeers = maxPeers
+	v.cycles = uint64(cycles)
+}
+
+// seen records that a message arrived from the peer, if it is tracked.
+func (v *peerVersions) seen(peerID string) {
+	v.Lock()
+	defer v.Unlock()
+	if p, ok := v.peers[peerID]; ok {
+		p.lastSeen = ktime.CycleNumber()
+	}
+}
+
+// announced tracks the peer with the versions it announced, and reports
+// whether the peer was not tracked before.
+func (v *peerVersions) announced(peerID string, versions map[string][]uint16) bool {
+	v.Lock()
+	defer v.Unlock()
+	p, ok := v.peers[peerID]
+	if !ok {
+		if len(v.peers) >= v.maxPeers {
+			return false
+		}
+		p = &versionPeer{}
+		v.peers[peerID] = p
+	}
+	p.versions = versions
+	p.lastSeen = ktime.CycleNumber()
+	return !ok
+}
+
+func (v *peerVersions) get(peerID string) (map[string][]uint16, bool) {
+	v.Lock()
+	defer v.Unlock()
+	p, ok := v.peers[peerID]
+	if !ok {
+		return nil, false
+	}
+	return p.versions, true
+}
+
+// maint forgets peers not heard from recently and decides whether
+// envelopes may be sent during the next cycle.
+func (v *peerVersions) maint() {
+	v.Lock()
+	defer v.Unlock()
+	now := ktime.CycleNumber()
+	for peerID, p := range v.peers {
+		if now-p.lastSeen > v.cycles {
+			delete(v.peers, peerID)
+		}
+	}
+	var e int32
+	if len(v.peers) > 0 {
+		e = 1
+	}
+	atomic.StoreInt32(&v.envelopes, e)
+}
+
+// NegotiatedVersion returns the highest format version of the channel
+// that both this node and the peer support.
+func (n *KernelNet) NegotiatedVersion(peerID string, channel *MessageChannel) (uint16, bool) {
+	pv, ok := n.peerVersions.get(peerID)
+	if !ok {
+		return 0, false
+	}
+	return negotiateVersion(channel.versions, pv[channel.Protocol.String()])
+}
+
+// broadcastVersion chooses the version in which to send a channel's
+// messages. Broadcasts reach every peer, so the lowest of the versions
+// negotiated with each peer is used. Peers with newer formats convert
+// the message up on receipt. Bare messages are always baseVersion.
+func (n *KernelNet) broadcastVersion(c *MessageChannel) uint16 {
+	if !n.useEnvelope(c) {
+		return baseVersion
+	}
+	version := c.Version()
+	if len(c.versions) == 1 {
+		return version
+	}
+	protocol := c.Protocol.String()
+	n.peerVersions.Lock()
+	defer n.peerVersions.Unlock()
+	for _, p := range n.peerVersions.peers {
+		v, ok := negotiateVersion(c.versions, p.versions[protocol])
+		if ok && v < version {
+			version = v
+		}
+	}
+	return version
+}
+
+// useEnvelope reports whether the channel's messages are sent in
+// envelopes. Channels that sign their messages or no longer support
+// baseVersion cannot be read by nodes that predate versioning, so they
+// always use envelopes.
+func (n *KernelNet) useEnvelope(c *MessageChannel) bool {
+	if c == n.versionChan || c.KeyStore != nil || !c.supports(baseVersion) {
+		return true
+	}
+	return atomic.LoadInt32(&n.peerVersions.envelopes) == 1
+}
+
+func negotiateVersion(local []uint16, remote []uint16) (uint16, bool) {
+	var best uint16
+	for _, l := range local {
+		for _, r := range remote {
+			if l == r && l > best {
+				best = l
+			}
+		}
+	}
+	return best, best > 0
+}
+
+// requestAnnounce schedules a version announcement for the next maint.
+func (n *KernelNet) requestAnnounce() {
+	atomic.StoreInt32(&n.peerVersions.announce, 1)
+}
+
+func (n *KernelNet) maintVersions() {
+	n.peerVersions.maint()
+	if atomic.CompareAndSwapInt32(&n.peerVersions.announce, 1, 0) {
+		n.announceVersions()
+	}
+}
+
+func (n *KernelNet) announceVersions() {
+	atomic.StoreInt32(&n.peerVersions.announce, 0)
+	a := &versionAnnouncement{Peer: n.PeerID(), Versions: make(map[string][]uint16)}
+	n.channels.Range(func(p, c interface{}) bool {
+		channel := c.(*MessageChannel)
+		if channel != n.versionChan {
+			a.Versions[p.(string)] = channel.Versions()
+		}
+		return true
+	})
+
+	netMsg, err := n.versionChan.marshal(a)
+	if err != nil {
+		glog.Errorln("Failed to make version announcement:", err)
+		return
+	}
+	n.priorityBroadcast(netMsg)
+}
+
+// versionHandler tracks the sender of an accepted announcement with the
+// versions it announced. The Peer field of the announcement is not
+// trusted; the sender is as authenticated as the version channel's
+// KeyStore makes it.
+func (n *KernelNet) versionHandler(netMsg *spec.NetworkMessage) {
+	if netMsg.From == n.PeerID() {
+		return
+	}
+	item, err := n.versionChan.unmarshal(netMsg)
+	if err != nil {
+		glog.Warningf("Failed to unmarshal version announcement: %v", err)
+		return
+	}
+	if item.Hash() != netMsg.Hash {
+		metrics.addHashMismatch(netMsg)
+		glog.Warningf("version announcement does not match message hash from %s", netMsg.From)
+		return
+	}
+	a := item.(*versionAnnouncement)
+
+	if !n.peerVersions.announced(netMsg.From, a.Versions) {
+		return
+	}
+
+	if glog.V(2) {
+		n.channels.Range(func(p, c interface{}) bool {
+			v, ok := negotiateVersion(c.(*MessageChannel).versions, a.Versions[p.(string)])
+			if ok {
+				glog.Infof("%s: peer %s negotiated %s version %d", ktime.String(), netMsg.From, p.(string), v)
+			}
+			return true
+		})
+	}
+
+	n.requestAnnounce()
+}
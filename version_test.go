@@ -0,0 +1,80 @@
+// Copyright © 2018 J. Strobus White.
+// This file is part of the blocktop blockchain development kit.
+//
+// Blocktop is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Blocktop is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with blocktop. If not, see <http://www.gnu.org/licenses/>.
+
+package kernel_test
+
+import (
+	"fmt"
+	"testing"
+
+	kernel "github.com/blocktop/go-kernel"
+	"github.com/blocktop/go-kernel/kerneltest"
+	spec "github.com/blocktop/go-spec"
+)
+
+// broadcastOf returns the broadcast of the block, or nil.
+func broadcastOf(h *kerneltest.Harness, block spec.Block) *spec.NetworkMessage {
+	for _, netMsg := range h.Network.Broadcasts() {
+		if netMsg.Hash == block.Hash() {
+			return netMsg
+		}
+	}
+	return nil
+}
+
+func TestEnvelopesFollowAnnouncedPeers(t *testing.T) {
+	// Take the version announcement of a peer.
+	peer := kerneltest.NewHarness("test", "peer")
+	peer.Init()
+	step(t, peer)
+	kernel.Stop()
+	broadcasts := peer.Network.Broadcasts()
+	if len(broadcasts) != 1 {
+		t.Fatalf("peer made %d broadcasts, want its version announcement", len(broadcasts))
+	}
+	announcement := broadcasts[0]
+
+	h := kerneltest.NewHarness("test", "node")
+	h.Config.Genesis = true
+	h.Config.MaxVersionPeers = 8
+	h.Consensus.Script(chainOf(0))
+	h.Init()
+	defer kernel.Stop()
+
+	// Messages from senders that never announce, more than are tracked,
+	// do not keep envelopes off.
+	spoofed := kerneltest.NewBlock(5, "spoofed", nil)
+	for i := 0; i < h.Config.MaxVersionPeers+10; i++ {
+		if err := h.Deliver(spoofed, fmt.Sprintf("spoofer%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Network.Deliver(announcement)
+	step(t, h)
+	step(t, h)
+
+	generated := h.Blockchain.Generated()
+	if len(generated) != 2 {
+		t.Fatalf("generated %d blocks, want 2", len(generated))
+	}
+	bare, enveloped := broadcastOf(h, generated[0]), broadcastOf(h, generated[1])
+	if bare == nil || enveloped == nil {
+		t.Fatal("generated blocks were not broadcast")
+	}
+	if bare.Protocol.String() == enveloped.Protocol.String() {
+		t.Errorf("block sent on %s after a peer announced, want the envelope protocol", enveloped.Protocol.String())
+	}
+}